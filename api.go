@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/rainbond/health-console/collectors"
+	"github.com/rainbond/health-console/config"
+)
+
+// maxTargetBytes bounds the request body of the target API
+const maxTargetBytes = 1 << 20
+
+// Target API errors, mapped to HTTP status codes by writeAPIError
+var (
+	errTargetNotFound = errors.New("target not found")
+	errTargetExists   = errors.New("target already exists")
+	errInvalidTarget  = errors.New("invalid target")
+)
+
+// targetAPI serves the runtime target API under /api/targets
+// Changes are persisted to the ConfigMap of the store and applied through the reloader
+type targetAPI struct {
+	reloader *reloader
+	secrets  *collectors.SecretResolver
+	token    config.Secret // Read again on every request, so it can be rotated
+}
+
+// register adds the routes of the target API to mux
+func (a *targetAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/targets", a.authorized(a.list))
+	databaseTargets.register(mux, a, "databases")
+	registryTargets.register(mux, a, "registries")
+	probeTargets.register(mux, a, "probes")
+	mux.HandleFunc("GET /api/targets/minio", a.authorized(a.getMinIO))
+	mux.HandleFunc("PUT /api/targets/minio", a.authorized(a.putMinIO))
+	mux.HandleFunc("DELETE /api/targets/minio", a.authorized(a.deleteMinIO))
+}
+
+// authorized wraps a handler with the bearer token check
+func (a *targetAPI) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := a.secrets.Resolve(r.Context(), a.token)
+		if err != nil {
+			log.Printf("Error reading the target API token: %v", err)
+			writeJSON(w, http.StatusServiceUnavailable, apiError{Error: "failed to read the target API token"})
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="health-console"`)
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "unauthorized"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// list returns every runtime target
+func (a *targetAPI) list(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+	defer cancel()
+
+	snapshot, err := a.reloader.store.Load(ctx)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	t := snapshot.Targets
+	writeJSON(w, http.StatusOK, config.RuntimeTargets{
+		Databases:  databaseTargets.redactAll(t.Databases),
+		Registries: registryTargets.redactAll(t.Registries),
+		Probes:     probeTargets.redactAll(t.Probes),
+		MinIO:      redactMinIO(t.MinIO),
+	})
+}
+
+// checkCredentials refuses credentials read from files or from Secrets other than the one of the
+// store, see targets.Store.CheckCredentials
+func (a *targetAPI) checkCredentials(t *config.RuntimeTargets) error {
+	if err := a.reloader.store.CheckCredentials(t); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidTarget, err)
+	}
+	return nil
+}
+
+// update changes the runtime targets and writes the target named by find from the saved targets
+func (a *targetAPI) update(w http.ResponseWriter, r *http.Request, status int,
+	update func(*config.RuntimeTargets, *config.Config) error, find func(*config.RuntimeTargets) any) {
+	ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+	defer cancel()
+
+	saved, err := a.reloader.updateTargets(ctx, update)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if find == nil {
+		w.WriteHeader(status)
+		return
+	}
+	writeJSON(w, status, find(saved.Targets))
+}
+
+// getMinIO returns the runtime MinIO
+func (a *targetAPI) getMinIO(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+	defer cancel()
+
+	snapshot, err := a.reloader.store.Load(ctx)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if snapshot.Targets.MinIO == nil {
+		writeAPIError(w, fmt.Errorf("%w: minio", errTargetNotFound))
+		return
+	}
+	writeJSON(w, http.StatusOK, redactMinIO(snapshot.Targets.MinIO))
+}
+
+// putMinIO sets the runtime MinIO
+func (a *targetAPI) putMinIO(w http.ResponseWriter, r *http.Request) {
+	minio, err := decodeTarget[config.MinIOConfig](w, r)
+	if err == nil {
+		err = a.checkCredentials(&config.RuntimeTargets{MinIO: &minio})
+	}
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	a.update(w, r, http.StatusOK, func(t *config.RuntimeTargets, cfg *config.Config) error {
+		if cfg.MinIO.Endpoint != "" {
+			return fmt.Errorf("%w: minio is configured in the config file or environment", errTargetExists)
+		}
+		t.MinIO = &minio
+		return nil
+	}, func(t *config.RuntimeTargets) any { return redactMinIO(t.MinIO) })
+}
+
+// deleteMinIO removes the runtime MinIO
+func (a *targetAPI) deleteMinIO(w http.ResponseWriter, r *http.Request) {
+	a.update(w, r, http.StatusNoContent, func(t *config.RuntimeTargets, cfg *config.Config) error {
+		if t.MinIO == nil {
+			return fmt.Errorf("%w: minio", errTargetNotFound)
+		}
+		t.MinIO = nil
+		return nil
+	}, nil)
+}
+
+// targetList describes a list of runtime targets of one kind
+type targetList[T any] struct {
+	kind       string
+	targets    func(*config.RuntimeTargets) *[]T
+	configured func(*config.Config) []T
+	name       func(T) string
+	setName    func(*T, string)
+	redact     func(T) T
+}
+
+var databaseTargets = targetList[config.DatabaseConfig]{
+	kind:       "database",
+	targets:    func(t *config.RuntimeTargets) *[]config.DatabaseConfig { return &t.Databases },
+	configured: func(cfg *config.Config) []config.DatabaseConfig { return cfg.Databases },
+	name:       func(db config.DatabaseConfig) string { return db.Name },
+	setName:    func(db *config.DatabaseConfig, name string) { db.Name = name },
+	redact: func(db config.DatabaseConfig) config.DatabaseConfig {
+		db.Password = config.RedactedSecret(db.Password)
+		db.TLS.Key = config.RedactedSecret(db.TLS.Key)
+		return db
+	},
+}
+
+var registryTargets = targetList[config.RegistryConfig]{
+	kind:       "registry",
+	targets:    func(t *config.RuntimeTargets) *[]config.RegistryConfig { return &t.Registries },
+	configured: func(cfg *config.Config) []config.RegistryConfig { return cfg.Registries },
+	name:       func(registry config.RegistryConfig) string { return registry.Name },
+	setName:    func(registry *config.RegistryConfig, name string) { registry.Name = name },
+	redact: func(registry config.RegistryConfig) config.RegistryConfig {
+		registry.Password = config.RedactedSecret(registry.Password)
+		return registry
+	},
+}
+
+// probeTargets carry no credentials
+var probeTargets = targetList[config.ProbeConfig]{
+	kind:       "probe",
+	targets:    func(t *config.RuntimeTargets) *[]config.ProbeConfig { return &t.Probes },
+	configured: func(cfg *config.Config) []config.ProbeConfig { return cfg.Probes },
+	name:       func(probe config.ProbeConfig) string { return probe.Name },
+	setName:    func(probe *config.ProbeConfig, name string) { probe.Name = name },
+	redact:     func(probe config.ProbeConfig) config.ProbeConfig { return probe },
+}
+
+// register adds the routes of the list under /api/targets/<plural>
+func (l targetList[T]) register(mux *http.ServeMux, a *targetAPI, plural string) {
+	base := "/api/targets/" + plural
+	mux.HandleFunc("GET "+base, a.authorized(l.handleList(a)))
+	mux.HandleFunc("POST "+base, a.authorized(l.handleCreate(a)))
+	mux.HandleFunc("GET "+base+"/{name}", a.authorized(l.handleGet(a)))
+	mux.HandleFunc("PUT "+base+"/{name}", a.authorized(l.handlePut(a)))
+	mux.HandleFunc("DELETE "+base+"/{name}", a.authorized(l.handleDelete(a)))
+}
+
+// handleList returns the targets of the list
+func (l targetList[T]) handleList(a *targetAPI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+		defer cancel()
+
+		snapshot, err := a.reloader.store.Load(ctx)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, l.redactAll(*l.targets(snapshot.Targets)))
+	}
+}
+
+// handleGet returns the target named in the path
+func (l targetList[T]) handleGet(a *targetAPI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+		defer cancel()
+
+		snapshot, err := a.reloader.store.Load(ctx)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		name := r.PathValue("name")
+		i := l.index(*l.targets(snapshot.Targets), name)
+		if i < 0 {
+			writeAPIError(w, fmt.Errorf("%w: %s %q", errTargetNotFound, l.kind, name))
+			return
+		}
+		writeJSON(w, http.StatusOK, l.redact((*l.targets(snapshot.Targets))[i]))
+	}
+}
+
+// handleCreate adds the target of the body, failing if a target of its name exists
+func (l targetList[T]) handleCreate(a *targetAPI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, err := decodeTarget[T](w, r)
+		if err == nil {
+			err = l.checkCredentials(a, target)
+		}
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		name := l.name(target)
+		if name == "" {
+			writeAPIError(w, fmt.Errorf("%w: name is required", errInvalidTarget))
+			return
+		}
+
+		a.update(w, r, http.StatusCreated, func(t *config.RuntimeTargets, cfg *config.Config) error {
+			if err := l.checkConfigured(cfg, name); err != nil {
+				return err
+			}
+			targets := l.targets(t)
+			if l.index(*targets, name) >= 0 {
+				return fmt.Errorf("%w: %s %q", errTargetExists, l.kind, name)
+			}
+			*targets = append(*targets, target)
+			return nil
+		}, l.find(name))
+	}
+}
+
+// handlePut adds or replaces the target named in the path with the target of the body
+func (l targetList[T]) handlePut(a *targetAPI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, err := decodeTarget[T](w, r)
+		if err == nil {
+			err = l.checkCredentials(a, target)
+		}
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		name := r.PathValue("name")
+		switch l.name(target) {
+		case "":
+			l.setName(&target, name)
+		case name:
+		default:
+			writeAPIError(w, fmt.Errorf("%w: name %q does not match the path", errInvalidTarget, l.name(target)))
+			return
+		}
+
+		a.update(w, r, http.StatusOK, func(t *config.RuntimeTargets, cfg *config.Config) error {
+			if err := l.checkConfigured(cfg, name); err != nil {
+				return err
+			}
+			targets := l.targets(t)
+			if i := l.index(*targets, name); i >= 0 {
+				(*targets)[i] = target
+			} else {
+				*targets = append(*targets, target)
+			}
+			return nil
+		}, l.find(name))
+	}
+}
+
+// handleDelete removes the target named in the path
+func (l targetList[T]) handleDelete(a *targetAPI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		a.update(w, r, http.StatusNoContent, func(t *config.RuntimeTargets, cfg *config.Config) error {
+			targets := l.targets(t)
+			i := l.index(*targets, name)
+			if i < 0 {
+				return fmt.Errorf("%w: %s %q", errTargetNotFound, l.kind, name)
+			}
+			*targets = slices.Delete(*targets, i, i+1)
+			return nil
+		}, nil)
+	}
+}
+
+// checkCredentials refuses credentials of target the API may not set
+func (l targetList[T]) checkCredentials(a *targetAPI, target T) error {
+	var t config.RuntimeTargets
+	*l.targets(&t) = []T{target}
+	return a.checkCredentials(&t)
+}
+
+// checkConfigured refuses a name used by a target of the config file or environment, which
+// would take precedence over the runtime target
+func (l targetList[T]) checkConfigured(cfg *config.Config, name string) error {
+	if l.index(l.configured(cfg), name) >= 0 {
+		return fmt.Errorf("%w: %s %q is configured in the config file or environment", errTargetExists, l.kind, name)
+	}
+	return nil
+}
+
+// index returns the index of the target called name, or -1
+func (l targetList[T]) index(targets []T, name string) int {
+	return slices.IndexFunc(targets, func(target T) bool { return l.name(target) == name })
+}
+
+// find returns a function picking the redacted target called name from saved targets
+func (l targetList[T]) find(name string) func(*config.RuntimeTargets) any {
+	return func(t *config.RuntimeTargets) any {
+		targets := *l.targets(t)
+		return l.redact(targets[l.index(targets, name)])
+	}
+}
+
+// redactAll returns the targets with their credentials redacted
+func (l targetList[T]) redactAll(targets []T) []T {
+	redacted := make([]T, 0, len(targets))
+	for _, target := range targets {
+		redacted = append(redacted, l.redact(target))
+	}
+	return redacted
+}
+
+// redactMinIO returns minio with its credentials redacted
+func redactMinIO(minio *config.MinIOConfig) *config.MinIOConfig {
+	if minio == nil {
+		return nil
+	}
+	redacted := *minio
+	redacted.AccessKey = config.RedactedSecret(minio.AccessKey)
+	redacted.SecretKey = config.RedactedSecret(minio.SecretKey)
+	return &redacted
+}
+
+// decodeTarget decodes a target from the JSON body of a request, rejecting unknown fields
+func decodeTarget[T any](w http.ResponseWriter, r *http.Request) (T, error) {
+	var target T
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTargetBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&target); err != nil {
+		return target, fmt.Errorf("%w: %w", errInvalidTarget, err)
+	}
+	return target, nil
+}
+
+// apiError is the body of target API errors
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeAPIError writes err with the status code matching its kind
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errInvalidTarget):
+		status = http.StatusBadRequest
+	case errors.Is(err, errTargetNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errTargetExists), apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		// The ConfigMap was changed or created concurrently, the request can be retried
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+// writeJSON writes value as a JSON response
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
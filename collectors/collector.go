@@ -0,0 +1,85 @@
+package collectors
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rainbond/health-console/config"
+)
+
+// Collector groups related health checks that share configuration and clients
+type Collector interface {
+	// Name returns the collector name used in logs and check names
+	Name() string
+
+	// Checks returns the checks this collector contributes to the scheduler
+	Checks() []Check
+
+	// Start prepares the collector before its checks are scheduled
+	Start(ctx context.Context) error
+
+	// Stop releases resources held by the collector
+	Stop(ctx context.Context) error
+}
+
+// Check is a single health check whose timing is owned by the Scheduler
+type Check struct {
+	// Name uniquely identifies the check, e.g. "database/internal"
+	Name string
+
+	// Interval is the time between two runs of the check
+	Interval time.Duration
+
+	// Timeout bounds a single run; the context passed to Run carries this deadline
+	Timeout time.Duration
+
+	// Jitter is the upper bound of a random delay added before each run
+	Jitter time.Duration
+
+	// Metric is the availability gauge the results are published to, if any
+	Metric *prometheus.GaugeVec
+
+	// ValueMetric receives the result values, labelled with the result labels plus "key", if any
+	ValueMetric *prometheus.GaugeVec
+
+	// ValueGauges receive the result values of their key instead, labelled with the result labels
+	ValueGauges map[string]*prometheus.GaugeVec
+
+	// InfoMetric receives the result info as a single series set to 1, labelled with the result
+	// labels plus the info keys, if any
+	InfoMetric *prometheus.GaugeVec
+
+	// Source tells whether the target was configured manually or discovered from the cluster,
+	// config.SourceManual, config.SourceDiscovered, config.SourceAPI
+	// or config.SourceHealthCheck
+	Source string
+
+	// DependsOn names the checks this check needs to be meaningful; while one of them is not up
+	// the check is reported as unknown instead of down. Unregistered dependencies are ignored
+	DependsOn []string
+
+	// Run performs the check once and returns one result per target
+	Run func(ctx context.Context) []CheckResult
+}
+
+// newCheck creates a check scheduled according to the given check configuration
+func newCheck(name string, cc config.CheckConfig, metric *prometheus.GaugeVec, run func(ctx context.Context) []CheckResult) Check {
+	return Check{
+		Name:     name,
+		Interval: cc.Interval,
+		Timeout:  cc.Timeout,
+		Jitter:   cc.Jitter,
+		Metric:   metric,
+		Source:   config.SourceManual,
+		Run:      run,
+	}
+}
+
+// single adapts a check of a single target to the Check.Run signature
+func single(run func(ctx context.Context) CheckResult) func(ctx context.Context) []CheckResult {
+	return func(ctx context.Context) []CheckResult {
+		return []CheckResult{run(ctx)}
+	}
+}
@@ -1,113 +1,303 @@
 package collectors
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"net"
+	"strconv"
 	"strings"
-	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 
 	"github.com/rainbond/health-console/config"
 	"github.com/rainbond/health-console/metrics"
 )
 
+// tlsConfigPrefix prefixes the names the TLS configs of the databases are registered under with
+// the driver, so they cannot clash with configs registered by name in a tls param
+const tlsConfigPrefix = "health-console/"
+
 // DatabaseCollector monitors database health
 type DatabaseCollector struct {
 	databases []config.DatabaseConfig
-	interval  time.Duration
-	ctx       context.Context
-	cancel    context.CancelFunc
+	pool      config.DatabasePoolConfig
+	secrets   *SecretResolver
+	pools     *DatabasePools
 }
 
-// NewDatabaseCollector creates a new database collector
-func NewDatabaseCollector(cfg *config.Config) *DatabaseCollector {
-	ctx, cancel := context.WithCancel(context.Background())
+// NewDatabaseCollector creates a new database collector checking through the connection pools
+// kept in pools
+func NewDatabaseCollector(cfg *config.Config, secrets *SecretResolver, pools *DatabasePools) *DatabaseCollector {
 	return &DatabaseCollector{
 		databases: cfg.Databases,
-		interval:  cfg.CollectInterval,
-		ctx:       ctx,
-		cancel:    cancel,
+		pool:      cfg.DatabasePool,
+		secrets:   secrets,
+		pools:     pools,
 	}
 }
 
-// Start begins collecting database metrics
-func (c *DatabaseCollector) Start() {
-	log.Println("Starting database collector...")
-
-	// Initial check
-	c.collect()
-
-	// Periodic checks
-	ticker := time.NewTicker(c.interval)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				c.collect()
-			case <-c.ctx.Done():
-				ticker.Stop()
-				return
-			}
-		}
-	}()
+// Name returns the collector name
+func (c *DatabaseCollector) Name() string {
+	return "database"
 }
 
-// Stop stops the collector
-func (c *DatabaseCollector) Stop() {
-	log.Println("Stopping database collector...")
-	c.cancel()
+// Checks returns one check per configured database instance
+func (c *DatabaseCollector) Checks() []Check {
+	checks := make([]Check, 0, len(c.databases))
+	for _, db := range c.databases {
+		db := db
+		check := newCheck("database/"+db.Name, db.Check, metrics.MySQLUp, single(func(ctx context.Context) CheckResult {
+			return c.checkDatabase(ctx, db)
+		}))
+		check.ValueMetric = metrics.MySQLPoolStats
+		check.ValueGauges = databaseGauges
+		check.InfoMetric = metrics.MySQLSchemaInfo
+		check.Source = db.Source
+		checks = append(checks, check)
+	}
+	return checks
 }
 
-// collect performs database health checks
-func (c *DatabaseCollector) collect() {
+// Start begins collecting database metrics
+func (c *DatabaseCollector) Start(ctx context.Context) error {
+	log.Println("Starting database collector...")
+	names := make([]string, 0, len(c.databases))
 	for _, db := range c.databases {
-		go c.checkDatabase(db)
+		names = append(names, db.Name)
 	}
+	c.pools.attach(c, names)
+	return nil
+}
+
+// Stop stops the collector, closing the connection pools no other collector uses
+func (c *DatabaseCollector) Stop(ctx context.Context) error {
+	log.Println("Stopping database collector...")
+	c.pools.detach(c)
+	return nil
 }
 
 // checkDatabase checks a single database instance
-func (c *DatabaseCollector) checkDatabase(dbConfig config.DatabaseConfig) {
-	start := time.Now()
-	defer func() {
-		metrics.HealthCheckDuration.WithLabelValues("database").Observe(time.Since(start).Seconds())
-	}()
-
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?timeout=5s",
-		dbConfig.Username,
-		dbConfig.Password,
-		dbConfig.Host,
-		dbConfig.Port,
-		dbConfig.Database,
-	)
-
-	db, err := sql.Open("mysql", dsn)
+func (c *DatabaseCollector) checkDatabase(ctx context.Context, dbConfig config.DatabaseConfig) CheckResult {
+	result := newResult("database", dbConfig.Name, map[string]string{
+		"instance": dbConfig.Name,
+		"host":     dbConfig.Host,
+		"port":     strconv.Itoa(dbConfig.Port),
+	})
+
+	password, err := c.secrets.Resolve(ctx, dbConfig.Password)
 	if err != nil {
-		errorReason := classifyDatabaseError(err)
-		log.Printf("Failed to open database connection for %s (%s:%d): %v [reason: %s]", dbConfig.Name, dbConfig.Host, dbConfig.Port, err, errorReason)
-		metrics.MySQLUp.WithLabelValues(dbConfig.Name, dbConfig.Host, fmt.Sprintf("%d", dbConfig.Port)).Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("database", "connection_failed").Inc()
-		return
+		return credentialsUnavailable(result, "database "+dbConfig.Name, err)
 	}
-	defer db.Close()
 
-	// Set connection timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// The TLS material is read on every check, like the password, so rotated certificates are picked up
+	var pem [3]string
+	for i, secret := range []config.Secret{dbConfig.TLS.CA, dbConfig.TLS.Cert, dbConfig.TLS.Key} {
+		if pem[i], err = c.secrets.Resolve(ctx, secret); err != nil {
+			return credentialsUnavailable(result, "database "+dbConfig.Name, err)
+		}
+	}
+	tlsConfig, err := databaseTLSConfig(dbConfig, pem[0], pem[1], pem[2])
+	if err != nil {
+		return result.down("tls_config_failed", "TLS证书错误",
+			fmt.Sprintf("Invalid TLS settings for database %s: %v", dbConfig.Name, err))
+	}
+
+	key := poolKey(dbConfig, c.pool, password, pem[:])
+	db, err := c.pools.get(dbConfig.Name, key, func() (*sql.DB, error) {
+		return openDatabase(dbConfig, c.pool, password, tlsConfig)
+	})
+	if err != nil {
+		return result.down("connection_failed", classifyDatabaseError(err),
+			fmt.Sprintf("Failed to open database connection for %s (%s:%d): %v", dbConfig.Name, dbConfig.Host, dbConfig.Port, err))
+	}
 
-	// Ping the database
+	// Ping the database, the pool opens a connection if it has no idle one
 	if err := db.PingContext(ctx); err != nil {
-		errorReason := classifyDatabaseError(err)
-		log.Printf("Database %s (%s:%d) is unreachable: %v [reason: %s]", dbConfig.Name, dbConfig.Host, dbConfig.Port, err, errorReason)
-		metrics.MySQLUp.WithLabelValues(dbConfig.Name, dbConfig.Host, fmt.Sprintf("%d", dbConfig.Port)).Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("database", "ping_failed").Inc()
-		return
+		result.Values = poolValues(db.Stats())
+		if dbConfig.Role != "" && isMySQLError(err, errBadDB) {
+			return result.down("schema_missing", "数据库不存在",
+				fmt.Sprintf("Database %s (%s:%d) has no %s schema %s: %v", dbConfig.Name, dbConfig.Host, dbConfig.Port, dbConfig.Role, dbConfig.Database, err))
+		}
+		return result.down("ping_failed", classifyDatabaseError(err),
+			fmt.Sprintf("Database %s (%s:%d) is unreachable: %v", dbConfig.Name, dbConfig.Host, dbConfig.Port, err))
+	}
+
+	// The server status does not decide the result, alerting rules judge the exported values
+	values, statusErr := serverStatus(ctx, db)
+	result.Values = values
+	message := fmt.Sprintf("Database %s (%s:%d) is healthy", dbConfig.Name, dbConfig.Host, dbConfig.Port)
+	if dbConfig.Role != "" {
+		schema, err := verifySchema(ctx, db, dbConfig.Role)
+		if err != nil {
+			maps.Copy(values, poolValues(db.Stats()))
+			return result.down("schema_check_failed", classifyDatabaseError(err),
+				fmt.Sprintf("Failed to verify the %s schema of database %s (%s:%d): %v", dbConfig.Role, dbConfig.Name, dbConfig.Host, dbConfig.Port, err))
+		}
+		values["schema_missing_tables"] = float64(len(schema.missing))
+		result.Info = map[string]string{"role": dbConfig.Role, "version": schema.version}
+		if len(schema.missing) > 0 {
+			maps.Copy(values, poolValues(db.Stats()))
+			return result.down("schema_missing", "数据库表缺失",
+				fmt.Sprintf("Database %s (%s:%d) is reachable but its %s", dbConfig.Name, dbConfig.Host, dbConfig.Port, schema.describe(dbConfig.Role)))
+		}
+		message += ", " + schema.describe(dbConfig.Role)
+	}
+
+	// A ping succeeds on a full disk or a read-only instance, only a write tells
+	if dbConfig.Heartbeat.Enabled {
+		write, read, err := heartbeat(ctx, db, dbConfig.Heartbeat, dbConfig.Name)
+		if err != nil {
+			maps.Copy(values, poolValues(db.Stats()))
+			errorType, reason := classifyWriteError(err)
+			return result.down(errorType, reason,
+				fmt.Sprintf("Database %s (%s:%d) does not accept writes: %v", dbConfig.Name, dbConfig.Host, dbConfig.Port, err))
+		}
+		values["heartbeat_write_seconds"] = write.Seconds()
+		values["heartbeat_read_seconds"] = read.Seconds()
+	}
+	maps.Copy(values, poolValues(db.Stats()))
+
+	if statusErr != nil {
+		message += fmt.Sprintf(" (%s)", strings.ReplaceAll(statusErr.Error(), "\n", "; "))
+	}
+	return result.up(message)
+}
+
+// openDatabase opens a database handle bounded by pool, connections are made on first use
+// The connect timeout defaults to the check timeout, the configured params may override it.
+// A TLS config is registered with the driver under the name of the instance, until the pool is closed
+func openDatabase(dbConfig config.DatabaseConfig, pool config.DatabasePoolConfig, password string, tlsConfig *tls.Config) (*sql.DB, error) {
+	params := "timeout=" + dbConfig.Check.Timeout.String()
+	if len(dbConfig.Params) > 0 {
+		params += "&" + dbConfig.DriverParams()
+	}
+
+	cfg, err := mysql.ParseDSN("/?" + params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid params: %w", err)
 	}
+	cfg.User = dbConfig.Username
+	cfg.Passwd = password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(dbConfig.Host, strconv.Itoa(dbConfig.Port))
+	cfg.DBName = dbConfig.Database
+	if tlsConfig != nil {
+		name := tlsConfigPrefix + dbConfig.Name
+		if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+			return nil, err
+		}
+		cfg.TLSConfig = name
+		cfg.AllowFallbackToPlaintext = dbConfig.TLS.Mode == config.TLSPreferred
+	}
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		mysql.DeregisterTLSConfig(tlsConfigPrefix + dbConfig.Name)
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(pool.MaxOpen)
+	db.SetMaxIdleConns(pool.MaxIdle)
+	db.SetConnMaxLifetime(pool.MaxLifetime)
+	return db, nil
+}
+
+// poolKey fingerprints the settings a pool is opened with, including the resolved password and
+// TLS material, so that the pool is reopened when any of them changes
+func poolKey(dbConfig config.DatabaseConfig, pool config.DatabasePoolConfig, password string, pem []string) string {
+	h := sha256.New()
+	for _, field := range append([]string{
+		dbConfig.Host, strconv.Itoa(dbConfig.Port), dbConfig.Username, password, dbConfig.Database,
+		dbConfig.DriverParams(), dbConfig.Check.Timeout.String(), dbConfig.TLS.Mode, dbConfig.TLS.ServerName,
+		fmt.Sprint(pool),
+	}, pem...) {
+		// Length-prefixed, so that no two settings produce the same input
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// poolValues returns the statistics of a pool as result values
+func poolValues(stats sql.DBStats) map[string]float64 {
+	return map[string]float64{
+		"open_connections":      float64(stats.OpenConnections),
+		"in_use":                float64(stats.InUse),
+		"idle":                  float64(stats.Idle),
+		"wait_count":            float64(stats.WaitCount),
+		"wait_duration_seconds": stats.WaitDuration.Seconds(),
+	}
+}
 
-	log.Printf("Database %s (%s:%d) is healthy", dbConfig.Name, dbConfig.Host, dbConfig.Port)
-	metrics.MySQLUp.WithLabelValues(dbConfig.Name, dbConfig.Host, fmt.Sprintf("%d", dbConfig.Port)).Set(1)
+// databaseTLSConfig builds the TLS config of a database from its resolved PEM CA bundle, client
+// certificate and key, or returns nil if the connection does not use one of the TLS modes
+func databaseTLSConfig(dbConfig config.DatabaseConfig, ca, cert, key string) (*tls.Config, error) {
+	mode := dbConfig.TLS.Mode
+	if mode == "" || mode == config.TLSDisabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if cert != "" {
+		pair, err := tls.X509KeyPair([]byte(cert), []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	var roots *x509.CertPool // The system roots if nil
+	if ca != "" {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(ca)) {
+			return nil, errors.New("tls ca contains no PEM certificate")
+		}
+	}
+
+	switch mode {
+	case config.TLSPreferred, config.TLSRequired:
+		tlsConfig.InsecureSkipVerify = true
+	case config.TLSVerifyCA:
+		// crypto/tls always checks the host name, so the chain is verified separately
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyChain(roots)
+	case config.TLSVerifyFull:
+		tlsConfig.RootCAs = roots
+		tlsConfig.ServerName = cmp.Or(dbConfig.TLS.ServerName, dbConfig.Host)
+	}
+	return tlsConfig, nil
+}
+
+// verifyChain returns a tls.Config.VerifyPeerCertificate function checking that the server
+// certificate is signed by roots, whatever name it is issued for
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("tls: server sent no certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("tls: failed to parse server certificate: %w", err)
+			}
+			certs[i] = cert
+		}
+
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
 }
 
 // classifyDatabaseError classifies database errors for better troubleshooting
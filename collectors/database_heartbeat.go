@@ -0,0 +1,164 @@
+package collectors
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/rainbond/health-console/config"
+)
+
+// MySQL errors the write probe handles
+const (
+	errBadDB               = 1049 // ER_BAD_DB_ERROR, the heartbeat database is missing
+	errNoSuchTable         = 1146 // ER_NO_SUCH_TABLE, the heartbeat table is missing
+	errOptionPrevents      = 1290 // ER_OPTION_PREVENTS_STATEMENT, read_only or super_read_only is on
+	errReadOnlyTransaction = 1792 // ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION
+	errReadOnlyMode        = 1836 // ER_READ_ONLY_MODE
+	errInnoDBReadOnly      = 1874 // ER_INNODB_READ_ONLY, innodb_read_only or innodb_force_recovery
+	errDiskFull            = 1021 // ER_DISK_FULL
+	errRecordFileFull      = 1114 // ER_RECORD_FILE_FULL, "The table is full"
+	errLockWaitTimeout     = 1205 // ER_LOCK_WAIT_TIMEOUT
+	errDBAccessDenied      = 1044 // ER_DBACCESS_DENIED_ERROR
+	errTableAccessDenied   = 1142 // ER_TABLEACCESS_DENIED_ERROR
+	errStorageEngine       = 1030 // ER_GET_ERRNO, "Got error N from storage engine"
+	errErrorOnWrite        = 1026 // ER_ERROR_ON_WRITE
+)
+
+// heartbeatError is a failed step of the write probe, with the error type it is reported as
+type heartbeatError struct {
+	errorType string
+	err       error
+}
+
+func (e *heartbeatError) Error() string {
+	return e.err.Error()
+}
+
+func (e *heartbeatError) Unwrap() error {
+	return e.err
+}
+
+// heartbeat upserts the heartbeat row id, the name of the instance, into the heartbeat table with
+// the current time and reads it back, returning how long the write and the read took. The table,
+// and its database, are created if missing. Nothing but the heartbeat table is ever written
+func heartbeat(ctx context.Context, db *sql.DB, cfg config.DatabaseHeartbeatConfig, id string) (write, read time.Duration, err error) {
+	// The database name is validated, so quoting it is enough
+	table := fmt.Sprintf("`%s`.`%s`", cfg.Database, config.HeartbeatTable)
+	beat := time.Now().UnixNano()
+
+	write, read, err = writeHeartbeat(ctx, db, table, id, beat)
+	if isMySQLError(err, errBadDB, errNoSuchTable) {
+		if err := createHeartbeatTable(ctx, db, cfg.Database, table, isMySQLError(err, errBadDB)); err != nil {
+			return 0, 0, &heartbeatError{"heartbeat_setup_failed", fmt.Errorf("failed to create heartbeat table %s: %w", table, err)}
+		}
+		write, read, err = writeHeartbeat(ctx, db, table, id, beat)
+	}
+	return write, read, err
+}
+
+// writeHeartbeat upserts the heartbeat row and reads it back in one transaction. The row lock is
+// held until the commit, so replicas checking the same instance cannot change the row in between
+func writeHeartbeat(ctx context.Context, db *sql.DB, table, id string, beat int64) (write, read time.Duration, err error) {
+	start := time.Now()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, &heartbeatError{"write_failed", fmt.Errorf("failed to write heartbeat to %s: %w", table, err)}
+	}
+	defer tx.Rollback()
+
+	upsert := "INSERT INTO " + table + " (id, beat_ns) VALUES (?, ?) ON DUPLICATE KEY UPDATE beat_ns = VALUES(beat_ns)"
+	if _, err := tx.ExecContext(ctx, upsert, id, beat); err != nil {
+		return 0, 0, &heartbeatError{"write_failed", fmt.Errorf("failed to write heartbeat to %s: %w", table, err)}
+	}
+	write = time.Since(start)
+
+	start = time.Now()
+	var got int64
+	if err := tx.QueryRowContext(ctx, "SELECT beat_ns FROM "+table+" WHERE id = ?", id).Scan(&got); err != nil {
+		return write, 0, &heartbeatError{"read_failed", fmt.Errorf("failed to read heartbeat back from %s: %w", table, err)}
+	}
+	read = time.Since(start)
+	if got != beat {
+		return write, read, &heartbeatError{"heartbeat_mismatch", fmt.Errorf("heartbeat read back from %s is %d, %d was written", table, got, beat)}
+	}
+
+	// The write is only durable once committed, so the commit counts as part of it
+	start = time.Now()
+	if err := tx.Commit(); err != nil {
+		return write, read, &heartbeatError{"write_failed", fmt.Errorf("failed to commit heartbeat to %s: %w", table, err)}
+	}
+	write += time.Since(start)
+	return write, read, nil
+}
+
+// createHeartbeatTable creates the heartbeat table, and its database first if createDatabase
+func createHeartbeatTable(ctx context.Context, db *sql.DB, database, table string, createDatabase bool) error {
+	if createDatabase {
+		if _, err := db.ExecContext(ctx, "CREATE DATABASE IF NOT EXISTS `"+database+"`"); err != nil {
+			return err
+		}
+	}
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+table+` (
+		id VARCHAR(255) NOT NULL PRIMARY KEY,
+		beat_ns BIGINT NOT NULL,
+		updated_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6)
+	) ENGINE=InnoDB`)
+	return err
+}
+
+// classifyWriteError classifies a failed write probe into an error type and a reason, telling the
+// failures only a write reveals apart from those of any connection
+func classifyWriteError(err error) (string, string) {
+	var hbErr *heartbeatError
+	errorType := "write_failed"
+	if errors.As(err, &hbErr) {
+		errorType = hbErr.errorType
+	}
+	if errorType == "heartbeat_mismatch" {
+		return errorType, "心跳数据不一致"
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case errOptionPrevents, errReadOnlyTransaction, errReadOnlyMode:
+			return "read_only", "数据库只读"
+		case errInnoDBReadOnly:
+			return "innodb_read_only", "InnoDB拒绝写入"
+		case errDiskFull, errRecordFileFull:
+			return "disk_full", "磁盘空间不足"
+		case errLockWaitTimeout:
+			return "lock_wait_timeout", "写入锁等待超时"
+		case errDBAccessDenied, errTableAccessDenied:
+			return "write_denied", "写入权限不足"
+		case errStorageEngine, errErrorOnWrite:
+			// The errno is only in the message, 28 is ENOSPC
+			message := strings.ToLower(mysqlErr.Message)
+			if strings.Contains(message, "error 28 ") || strings.Contains(message, "errno: 28") || strings.Contains(message, "no space") {
+				return "disk_full", "磁盘空间不足"
+			}
+			return "storage_engine_error", "存储引擎错误"
+		}
+	}
+	return errorType, classifyDatabaseError(err)
+}
+
+// isMySQLError reports whether err is a MySQL error with one of the numbers
+func isMySQLError(err error, numbers ...uint16) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	for _, number := range numbers {
+		if mysqlErr.Number == number {
+			return true
+		}
+	}
+	return false
+}
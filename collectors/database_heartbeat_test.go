@@ -0,0 +1,152 @@
+package collectors
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/rainbond/health-console/config"
+)
+
+func TestClassifyWriteError(t *testing.T) {
+	// writeErr wraps a server error the way heartbeat returns a failed write
+	writeErr := func(number uint16, message string) error {
+		return &heartbeatError{"write_failed", fmt.Errorf("failed to write heartbeat to t: %w", &mysql.MySQLError{Number: number, Message: message})}
+	}
+
+	tests := []struct {
+		name          string
+		err           error
+		wantErrorType string
+		wantReason    string
+	}{
+		{"read_only", writeErr(errOptionPrevents, "The MySQL server is running with the --read-only option so it cannot execute this statement"), "read_only", "数据库只读"},
+		{"super_read_only", writeErr(errOptionPrevents, "The MySQL server is running with the --super-read-only option so it cannot execute this statement"), "read_only", "数据库只读"},
+		{"read-only transaction", writeErr(errReadOnlyTransaction, "Cannot execute statement in a READ ONLY transaction."), "read_only", "数据库只读"},
+		{"innodb read only", writeErr(errInnoDBReadOnly, "Running in read-only mode"), "innodb_read_only", "InnoDB拒绝写入"},
+		{"table access denied", writeErr(errTableAccessDenied, "INSERT command denied to user 'monitor'@'%' for table 'heartbeat'"), "write_denied", "写入权限不足"},
+		{"database access denied", writeErr(errDBAccessDenied, "Access denied for user 'monitor'@'%' to database 'health_console'"), "write_denied", "写入权限不足"},
+		// The message mentions a timeout, the number tells it is a lock and not the connection
+		{"lock wait timeout", writeErr(errLockWaitTimeout, "Lock wait timeout exceeded; try restarting transaction"), "lock_wait_timeout", "写入锁等待超时"},
+		{"disk full", writeErr(errDiskFull, "Disk full (/var/lib/mysql/x); waiting for someone to free some space..."), "disk_full", "磁盘空间不足"},
+		{"table full", writeErr(errRecordFileFull, "The table 'heartbeat' is full"), "disk_full", "磁盘空间不足"},
+		{"engine out of space", writeErr(errStorageEngine, "Got error 28 from storage engine"), "disk_full", "磁盘空间不足"},
+		{"engine error", writeErr(errStorageEngine, "Got error 168 from storage engine"), "storage_engine_error", "存储引擎错误"},
+		{"setup denied", &heartbeatError{"heartbeat_setup_failed", &mysql.MySQLError{Number: errDBAccessDenied, Message: "Access denied"}}, "write_denied", "写入权限不足"},
+		{"mismatch", &heartbeatError{"heartbeat_mismatch", errors.New("heartbeat read back from t is 1, 2 was written")}, "heartbeat_mismatch", "心跳数据不一致"},
+		{"read failure falls back to the connection reason", &heartbeatError{"read_failed", fmt.Errorf("failed to read heartbeat back from t: %w", context.DeadlineExceeded)}, "read_failed", "连接超时"},
+		{"unwrapped error", errors.New("dial tcp: connection refused"), "write_failed", "连接被拒绝"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errorType, reason := classifyWriteError(tt.err)
+			if errorType != tt.wantErrorType || reason != tt.wantReason {
+				t.Errorf("classifyWriteError(%v) = %q, %q, want %q, %q", tt.err, errorType, reason, tt.wantErrorType, tt.wantReason)
+			}
+		})
+	}
+}
+
+// heartbeatServer is a database server that only knows the heartbeat table, logging the first
+// word of every statement it runs
+type heartbeatServer struct {
+	mu         sync.Mutex
+	tableReady bool
+	rows       map[string]int64
+	log        []string
+}
+
+func (s *heartbeatServer) Connect(context.Context) (driver.Conn, error) { return heartbeatConn{s}, nil }
+func (s *heartbeatServer) Driver() driver.Driver                        { return nil }
+
+func (s *heartbeatServer) run(query string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = append(s.log, strings.Fields(query)[0])
+}
+
+type heartbeatConn struct{ s *heartbeatServer }
+
+func (c heartbeatConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c heartbeatConn) Close() error                        { return nil }
+func (c heartbeatConn) Begin() (driver.Tx, error)           { c.s.run("BEGIN"); return c, nil }
+func (c heartbeatConn) Commit() error                       { c.s.run("COMMIT"); return nil }
+func (c heartbeatConn) Rollback() error                     { c.s.run("ROLLBACK"); return nil }
+
+func (c heartbeatConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.s.run(query)
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	switch {
+	case strings.HasPrefix(query, "CREATE TABLE"):
+		c.s.tableReady = true
+	case strings.HasPrefix(query, "INSERT"):
+		if !c.s.tableReady {
+			return nil, &mysql.MySQLError{Number: errNoSuchTable, Message: "Table doesn't exist"}
+		}
+		c.s.rows[args[0].Value.(string)] = args[1].Value.(int64)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c heartbeatConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.s.run(query)
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	beat, ok := c.s.rows[args[0].Value.(string)]
+	return &heartbeatRows{beat: beat, done: !ok}, nil
+}
+
+type heartbeatRows struct {
+	beat int64
+	done bool
+}
+
+func (r *heartbeatRows) Columns() []string { return []string{"beat_ns"} }
+func (r *heartbeatRows) Close() error      { return nil }
+
+func (r *heartbeatRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.beat
+	return nil
+}
+
+func TestHeartbeatKeysRowOnInstance(t *testing.T) {
+	server := &heartbeatServer{rows: make(map[string]int64)}
+	db := sql.OpenDB(server)
+	defer db.Close()
+	cfg := config.DatabaseHeartbeatConfig{Enabled: true, Database: config.DefaultHeartbeatDatabase}
+
+	// Every check writes the same row, whichever process runs it
+	for range 3 {
+		if _, _, err := heartbeat(context.Background(), db, cfg, "console"); err != nil {
+			t.Fatalf("heartbeat() error = %v", err)
+		}
+	}
+	if len(server.rows) != 1 || server.rows["console"] == 0 {
+		t.Errorf("heartbeat rows = %v, want only the row of console", server.rows)
+	}
+
+	// The missing table is created outside of the transaction, then the row is written and read
+	// back in one transaction
+	want := []string{"BEGIN", "INSERT", "ROLLBACK", "CREATE", "BEGIN", "INSERT", "SELECT", "COMMIT"}
+	for range 2 {
+		want = append(want, "BEGIN", "INSERT", "SELECT", "COMMIT")
+	}
+	if !slices.Equal(server.log, want) {
+		t.Errorf("statements = %v, want %v", server.log, want)
+	}
+}
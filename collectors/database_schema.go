@@ -0,0 +1,108 @@
+package collectors
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rainbond/health-console/config"
+)
+
+// schemaTables are the core tables of the Rainbond schemas by database role. A schema missing any
+// of them is broken, e.g. by a failed upgrade or a connection to the wrong database
+var schemaTables = map[string][]string{
+	config.RoleConsole: {
+		"console_sys_config", "region_info", "service_group", "tenant_enterprise", "tenant_info",
+		"tenant_region", "tenant_service", "user_info",
+	},
+	config.RoleRegion: {
+		"region_user_info", "tenant_service_version", "tenant_services", "tenant_services_envs",
+		"tenant_services_port", "tenant_services_volume", "tenants",
+	},
+}
+
+// schemaStatus is what the schema verification of a database found
+type schemaStatus struct {
+	missing []string // Core tables that do not exist
+	version string   // Latest applied migration, empty if the schema records none
+}
+
+// verifySchema looks up the core tables of role in the database of the connection and the latest
+// migration applied to it
+func verifySchema(ctx context.Context, db *sql.DB, role string) (schemaStatus, error) {
+	var s schemaStatus
+	rows, err := db.QueryContext(ctx, "SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE()")
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+
+	tables := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return s, err
+		}
+		// Table names are case-insensitive with lower_case_table_names
+		tables[strings.ToLower(name)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return s, err
+	}
+
+	for _, table := range schemaTables[role] {
+		if !tables[table] {
+			s.missing = append(s.missing, table)
+		}
+	}
+	s.version, err = schemaVersion(ctx, db, tables)
+	return s, err
+}
+
+// schemaVersion returns the latest migration recorded in the schema with tables: by Django in
+// the console, or by golang-migrate. It is empty if the schema has neither
+func schemaVersion(ctx context.Context, db *sql.DB, tables map[string]bool) (string, error) {
+	switch {
+	case tables["django_migrations"]:
+		var app, name string
+		err := db.QueryRowContext(ctx, "SELECT app, name FROM django_migrations ORDER BY id DESC LIMIT 1").Scan(&app, &name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read django_migrations: %w", err)
+		}
+		return app + "." + name, nil
+	case tables["schema_migrations"]:
+		var version int64
+		var dirty bool
+		err := db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read schema_migrations: %w", err)
+		}
+		if dirty {
+			// A dirty version is a migration that failed halfway
+			return fmt.Sprintf("%d (dirty)", version), nil
+		}
+		return fmt.Sprint(version), nil
+	default:
+		return "", nil
+	}
+}
+
+// describe summarizes the schema status for the result message
+func (s schemaStatus) describe(role string) string {
+	version := "no recorded migration"
+	if s.version != "" {
+		version = "migration " + s.version
+	}
+	if len(s.missing) > 0 {
+		return fmt.Sprintf("%s schema is missing tables %s (%s)", role, strings.Join(s.missing, ", "), version)
+	}
+	return fmt.Sprintf("%s schema has its %d core tables (%s)", role, len(schemaTables[role]), version)
+}
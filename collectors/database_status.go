@@ -0,0 +1,156 @@
+package collectors
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rainbond/health-console/metrics"
+)
+
+// errParseError is the MySQL error of a statement the server does not understand, returned by
+// servers older than MySQL 8.0.22 and MariaDB 10.5.1 for SHOW REPLICA STATUS
+const errParseError = 1064
+
+// replicaColumns renames the replica status columns of servers before MySQL 8.0.22, which name
+// them Slave_ and Master_ rather than Replica_ and Source_
+var replicaColumns = strings.NewReplacer("Slave_", "Replica_", "_Master", "_Source")
+
+// databaseGauges are the gauges of the result values of a database check other than the pool
+// statistics, by result value key
+var databaseGauges = map[string]*prometheus.GaugeVec{
+	"threads_connected":             metrics.MySQLThreadsConnected,
+	"max_connections":               metrics.MySQLMaxConnections,
+	"uptime_seconds":                metrics.MySQLUptimeSeconds,
+	"read_only":                     metrics.MySQLReadOnly,
+	"super_read_only":               metrics.MySQLSuperReadOnly,
+	"replica_io_running":            metrics.MySQLReplicaIORunning,
+	"replica_sql_running":           metrics.MySQLReplicaSQLRunning,
+	"replica_seconds_behind_source": metrics.MySQLReplicaSecondsBehindSource,
+	"heartbeat_write_seconds":       metrics.MySQLHeartbeatWriteSeconds,
+	"heartbeat_read_seconds":        metrics.MySQLHeartbeatReadSeconds,
+	"schema_missing_tables":         metrics.MySQLSchemaMissingTables,
+}
+
+// serverStatus returns the connection usage, uptime, read-only state and, on a replica, the
+// replication state of a reachable instance as result values. A query that fails, e.g. for lack of
+// the REPLICATION CLIENT privilege, only leaves its values out, its error is returned with the
+// values of the other queries
+func serverStatus(ctx context.Context, db *sql.DB) (map[string]float64, error) {
+	values := make(map[string]float64)
+	var errs []error
+
+	status, err := showVariables(ctx, db, "SHOW GLOBAL STATUS WHERE Variable_name IN ('Threads_connected', 'Uptime')")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("status unavailable: %w", err))
+	}
+	setNumber(values, "threads_connected", status["threads_connected"])
+	setNumber(values, "uptime_seconds", status["uptime"])
+
+	variables, err := showVariables(ctx, db, "SHOW GLOBAL VARIABLES WHERE Variable_name IN ('max_connections', 'read_only', 'super_read_only')")
+	if err != nil {
+		errs = append(errs, fmt.Errorf("variables unavailable: %w", err))
+	}
+	setNumber(values, "max_connections", variables["max_connections"])
+	// super_read_only does not exist on MariaDB and MySQL before 5.7.8
+	setSwitch(values, "read_only", variables["read_only"])
+	setSwitch(values, "super_read_only", variables["super_read_only"])
+
+	if err := replicaStatus(ctx, db, values); err != nil {
+		errs = append(errs, fmt.Errorf("replication status unavailable: %w", err))
+	}
+	return values, errors.Join(errs...)
+}
+
+// showVariables runs a SHOW STATUS or SHOW VARIABLES statement and returns the values by
+// lowercase variable name
+func showVariables(ctx context.Context, db *sql.DB, query string) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variables := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		variables[strings.ToLower(name)] = value
+	}
+	return variables, rows.Err()
+}
+
+// replicaStatus adds the state of the replication threads and the replication lag to values if the
+// instance is a replica. With several replication channels a thread counts as running only if it
+// runs on every channel, and the lag is that of the channel furthest behind
+func replicaStatus(ctx context.Context, db *sql.DB, values map[string]float64) error {
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if isMySQLError(err, errParseError) {
+		rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		fields := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range fields {
+			dest[i] = &fields[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		channel := make(map[string]sql.NullString, len(columns))
+		for i, column := range columns {
+			channel[replicaColumns.Replace(column)] = fields[i]
+		}
+
+		for key, column := range map[string]string{"replica_io_running": "Replica_IO_Running", "replica_sql_running": "Replica_SQL_Running"} {
+			running := 0.0
+			// The IO thread is Connecting while it cannot reach the source, which is not running either
+			if channel[column].String == "Yes" {
+				running = 1
+			}
+			if current, ok := values[key]; !ok || running < current {
+				values[key] = running
+			}
+		}
+		// The lag is NULL while a replication thread is stopped
+		if lag, err := strconv.ParseFloat(channel["Seconds_Behind_Source"].String, 64); err == nil {
+			if current, ok := values["replica_seconds_behind_source"]; !ok || lag > current {
+				values["replica_seconds_behind_source"] = lag
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// setNumber sets a numeric value, it is left out if missing or not a number
+func setNumber(values map[string]float64, key, value string) {
+	if v, err := strconv.ParseFloat(value, 64); err == nil {
+		values[key] = v
+	}
+}
+
+// setSwitch sets a boolean variable as 1 or 0, it is left out if missing
+func setSwitch(values map[string]float64, key, value string) {
+	switch strings.ToUpper(value) {
+	case "ON", "1":
+		values[key] = 1
+	case "OFF", "0":
+		values[key] = 0
+	}
+}
@@ -2,138 +2,203 @@ package collectors
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"maps"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 
 	"github.com/rainbond/health-console/config"
+	"github.com/rainbond/health-console/kube"
 	"github.com/rainbond/health-console/metrics"
 )
 
+// testPVCSelector matches the test PVCs created by storage class checks
+const testPVCSelector = "app=health-console,purpose=storage-test"
+
+// testPVCProcessLabel labels the test PVCs with the process that created them, so that a starting
+// collector only cleans up those left behind by earlier processes
+const testPVCProcessLabel = "health-console-process"
+
+// processID identifies this process in the labels of its test PVCs
+var processID = newProcessID()
+
+// Names of the cluster checks other checks depend on
+const (
+	apiServerCheck = "kubernetes/apiserver"
+	coreDNSCheck   = "kubernetes/coredns"
+)
+
 // KubernetesCollector monitors Kubernetes cluster health
 type KubernetesCollector struct {
-	clientset *kubernetes.Clientset
-	interval  time.Duration
-	ctx       context.Context
-	cancel    context.CancelFunc
+	clientset   kubernetes.Interface
+	checks      config.KubernetesConfig
+	profileName string
+	profile     config.ClusterProfile
+	inCluster   bool // Cluster Service names only resolve through the host resolver in the cluster
+
+	// pvcs are the names of the test PVCs this collector created and has not deleted yet. A reload
+	// runs two collectors side by side, each must only clean up its own
+	mu   sync.Mutex
+	pvcs map[string]bool
 }
 
-// NewKubernetesCollector creates a new Kubernetes collector
-func NewKubernetesCollector(cfg *config.Config) (*KubernetesCollector, error) {
-	// Create in-cluster config
-	config, err := rest.InClusterConfig()
+// NewKubernetesCollector creates a new Kubernetes collector for the cluster of clients
+func NewKubernetesCollector(cfg *config.Config, clients *kube.Clients) (*KubernetesCollector, error) {
+	clientset, err := clients.Kubernetes()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create in-cluster config: %w", err)
+		return nil, err
 	}
 
-	// Create clientset
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create clientset: %w", err)
-	}
+	name, profile := resolveProfile(cfg.Kubernetes, clientset)
+	log.Printf("Using Kubernetes profile %s: DNS pods %s in %s, etcd strategy %s, test PVCs in %s",
+		name, profile.DNSSelector, profile.DNSNamespace, profile.EtcdStrategy, profile.ProbeNamespace)
 
-	ctx, cancel := context.WithCancel(context.Background())
 	return &KubernetesCollector{
-		clientset: clientset,
-		interval:  cfg.CollectInterval,
-		ctx:       ctx,
-		cancel:    cancel,
+		clientset:   clientset,
+		checks:      cfg.Kubernetes,
+		profileName: name,
+		profile:     profile,
+		inCluster:   cfg.InCluster,
+		pvcs:        make(map[string]bool),
 	}, nil
 }
 
+// Name returns the collector name
+func (c *KubernetesCollector) Name() string {
+	return "kubernetes"
+}
+
+// Checks returns the cluster-level checks
+// Every check but the API server one goes through the API server, so they depend on it
+// The etcd check is left out for profiles whose etcd is not visible
+func (c *KubernetesCollector) Checks() []Check {
+	coreDNS := newCheck(coreDNSCheck, c.checks.CoreDNS, metrics.CoreDNSUp, single(c.checkCoreDNS))
+	coreDNS.DependsOn = []string{apiServerCheck}
+
+	etcd := newCheck("kubernetes/etcd", c.checks.Etcd, metrics.EtcdUp, single(c.checkEtcd))
+	etcd.DependsOn = []string{apiServerCheck}
+
+	storageClass := newCheck("kubernetes/storage_class", c.checks.StorageClass, metrics.ClusterStorageUp, c.checkStorageClasses)
+	storageClass.DependsOn = []string{apiServerCheck}
+
+	checks := []Check{
+		newCheck(apiServerCheck, c.checks.APIServer, metrics.KubernetesAPIServerUp, single(c.checkAPIServer)),
+		coreDNS,
+		etcd,
+		storageClass,
+	}
+	if c.profile.EtcdStrategy == config.EtcdNone {
+		checks = slices.DeleteFunc(checks, func(check Check) bool { return check.Name == etcd.Name })
+	}
+	return checks
+}
+
 // Start begins collecting Kubernetes metrics
-func (c *KubernetesCollector) Start() {
+func (c *KubernetesCollector) Start(ctx context.Context) error {
 	log.Println("Starting Kubernetes collector...")
 
-	// Initial check
-	c.collect()
-
-	// Periodic checks
-	ticker := time.NewTicker(c.interval)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				c.collect()
-			case <-c.ctx.Done():
-				ticker.Stop()
-				return
-			}
-		}
-	}()
+	// Remove test PVCs left behind by a previous process that did not shut down cleanly. Those of
+	// this process belong to the collector being replaced by a reload, which may still be using them
+	c.cleanupTestPVCs(ctx, testPVCSelector+","+testPVCProcessLabel+"!="+processID)
+	return nil
 }
 
 // Stop stops the collector
-func (c *KubernetesCollector) Stop() {
+// In-flight storage class checks delete their own test PVCs; those they could not delete are
+// removed here. Test PVCs of other collectors, e.g. the one replacing this one, are left alone
+func (c *KubernetesCollector) Stop(ctx context.Context) error {
 	log.Println("Stopping Kubernetes collector...")
-	c.cancel()
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	c.mu.Lock()
+	names := slices.Sorted(maps.Keys(c.pvcs))
+	c.mu.Unlock()
+	for _, name := range names {
+		c.deleteTestPVC(ctx, name)
+	}
+	return nil
 }
 
-// collect performs all Kubernetes health checks
-func (c *KubernetesCollector) collect() {
-	go c.checkAPIServer()
-	go c.checkCoreDNS()
-	go c.checkEtcd()
-	go c.checkStorageClasses()
+// cleanupTestPVCs deletes the test PVCs created by storage class checks matching selector
+func (c *KubernetesCollector) cleanupTestPVCs(ctx context.Context, selector string) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	pvcs, err := c.clientset.CoreV1().PersistentVolumeClaims(c.profile.ProbeNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to list test PVCs for cleanup: %v", err)
+		return
+	}
+
+	for _, pvc := range pvcs.Items {
+		if err := c.clientset.CoreV1().PersistentVolumeClaims(c.profile.ProbeNamespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{}); err != nil {
+			log.Printf("Warning: Failed to delete leftover test PVC %s: %v", pvc.Name, err)
+			continue
+		}
+		log.Printf("Cleaned up leftover test PVC %s", pvc.Name)
+	}
+}
+
+// deleteTestPVC deletes a test PVC created by this collector, it is forgotten once gone
+func (c *KubernetesCollector) deleteTestPVC(ctx context.Context, name string) error {
+	err := c.clientset.CoreV1().PersistentVolumeClaims(c.profile.ProbeNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Printf("Warning: Failed to delete test PVC %s: %v", name, err)
+		return err
+	}
+	c.mu.Lock()
+	delete(c.pvcs, name)
+	c.mu.Unlock()
+	return nil
 }
 
 // checkAPIServer checks if API Server is reachable
-func (c *KubernetesCollector) checkAPIServer() {
-	start := time.Now()
-	defer func() {
-		metrics.HealthCheckDuration.WithLabelValues("kubernetes_apiserver").Observe(time.Since(start).Seconds())
-	}()
+func (c *KubernetesCollector) checkAPIServer(ctx context.Context) CheckResult {
+	result := newResult("kubernetes_apiserver", "apiserver", nil)
 
-	// Try to get server version
-	_, err := c.clientset.Discovery().ServerVersion()
+	// Try to get server version, bounded by the check timeout
+	_, err := c.clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
 	if err != nil {
-		errorReason := classifyK8sError(err)
-		log.Printf("Kubernetes API Server is unreachable: %v [reason: %s]", err, errorReason)
-		metrics.KubernetesAPIServerUp.WithLabelValues().Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("kubernetes_apiserver", "unreachable").Inc()
-		return
+		return result.down("unreachable", classifyK8sError(err),
+			fmt.Sprintf("Kubernetes API Server is unreachable: %v", err))
 	}
 
-	log.Printf("Kubernetes API Server is healthy")
-	metrics.KubernetesAPIServerUp.WithLabelValues().Set(1)
+	return result.up("Kubernetes API Server is healthy")
 }
 
 // checkCoreDNS checks if CoreDNS is working properly
-func (c *KubernetesCollector) checkCoreDNS() {
-	start := time.Now()
-	defer func() {
-		metrics.HealthCheckDuration.WithLabelValues("coredns").Observe(time.Since(start).Seconds())
-	}()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func (c *KubernetesCollector) checkCoreDNS(ctx context.Context) CheckResult {
+	result := newResult("coredns", "coredns", nil)
 
-	// Check CoreDNS pods in kube-system namespace
-	pods, err := c.clientset.CoreV1().Pods("kube-system").List(ctx, metav1.ListOptions{
-		LabelSelector: "k8s-app=kube-dns",
+	// Check the CoreDNS pods where the cluster profile says they run
+	pods, err := c.clientset.CoreV1().Pods(c.profile.DNSNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: c.profile.DNSSelector,
 	})
 	if err != nil {
-		errorReason := classifyK8sError(err)
-		log.Printf("Failed to list CoreDNS pods: %v [reason: %s]", err, errorReason)
-		metrics.CoreDNSUp.WithLabelValues().Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("coredns", "list_failed").Inc()
-		return
+		return result.down("list_failed", classifyK8sError(err),
+			fmt.Sprintf("Failed to list CoreDNS pods: %v", err))
 	}
 
 	if len(pods.Items) == 0 {
-		log.Printf("No CoreDNS pods found")
-		metrics.CoreDNSUp.WithLabelValues().Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("coredns", "no_pods").Inc()
-		return
+		return result.down("no_pods", "未找到Pod",
+			fmt.Sprintf("No CoreDNS pods found in %s matching %s (profile %s)", c.profile.DNSNamespace, c.profile.DNSSelector, c.profileName))
 	}
 
 	// Check if at least one CoreDNS pod is running and ready
@@ -153,66 +218,44 @@ func (c *KubernetesCollector) checkCoreDNS() {
 	}
 
 	if !hasReadyPod {
-		log.Printf("No ready CoreDNS pods found")
-		metrics.CoreDNSUp.WithLabelValues().Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("coredns", "no_ready_pods").Inc()
-		return
+		return result.down("no_ready_pods", "无就绪Pod", "No ready CoreDNS pods found")
+	}
+
+	// Out of the cluster the host resolver does not use CoreDNS, a lookup would always fail
+	if !c.inCluster {
+		return result.up("CoreDNS pods are ready, resolution is not tested out of the cluster")
 	}
 
 	// Perform DNS resolution test
-	_, err = net.LookupHost("kubernetes.default.svc.cluster.local")
+	_, err = net.DefaultResolver.LookupHost(ctx, "kubernetes.default.svc.cluster.local")
 	if err != nil {
-		errorReason := classifyDNSError(err)
-		log.Printf("DNS resolution test failed: %v [reason: %s]", err, errorReason)
-		metrics.CoreDNSUp.WithLabelValues().Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("coredns", "resolution_failed").Inc()
-		return
+		return result.down("resolution_failed", classifyDNSError(err),
+			fmt.Sprintf("DNS resolution test failed: %v", err))
 	}
 
-	log.Printf("CoreDNS is healthy")
-	metrics.CoreDNSUp.WithLabelValues().Set(1)
+	return result.up("CoreDNS is healthy")
 }
 
-// checkEtcd checks if Etcd cluster is available
-func (c *KubernetesCollector) checkEtcd() {
-	start := time.Now()
-	defer func() {
-		metrics.HealthCheckDuration.WithLabelValues("etcd").Observe(time.Since(start).Seconds())
-	}()
+// checkEtcd checks if Etcd cluster is available, using the etcd strategy of the cluster profile
+func (c *KubernetesCollector) checkEtcd(ctx context.Context) CheckResult {
+	result := newResult("etcd", "etcd", nil)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	if c.profile.EtcdStrategy == config.EtcdAPIServer {
+		return c.checkEtcdViaAPIServer(ctx, result)
+	}
 
-	// Check etcd pods in kube-system namespace
-	// In some clusters, etcd might be running as static pods or outside the cluster
-	pods, err := c.clientset.CoreV1().Pods("kube-system").List(ctx, metav1.ListOptions{
-		LabelSelector: "component=etcd",
+	// Check the etcd pods where the cluster profile says they run
+	pods, err := c.clientset.CoreV1().Pods(c.profile.EtcdNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: c.profile.EtcdSelector,
 	})
 	if err != nil {
-		errorReason := classifyK8sError(err)
-		log.Printf("Failed to list etcd pods: %v [reason: %s]", err, errorReason)
-		metrics.EtcdUp.WithLabelValues().Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("etcd", "list_failed").Inc()
-		return
+		return result.down("list_failed", classifyK8sError(err),
+			fmt.Sprintf("Failed to list etcd pods: %v", err))
 	}
 
-	// If no etcd pods found, try to check via API Server health
-	// (API Server depends on etcd, so if API Server is up, etcd is likely up)
+	// etcd may run outside the cluster, ask the API Server instead
 	if len(pods.Items) == 0 {
-		// Check API Server livez endpoint which includes etcd check
-		req := c.clientset.Discovery().RESTClient().Get().AbsPath("/livez")
-		result := req.Do(ctx)
-		if err := result.Error(); err != nil {
-			errorReason := classifyK8sError(err)
-			log.Printf("Etcd health check via API Server failed: %v [reason: %s]", err, errorReason)
-			metrics.EtcdUp.WithLabelValues().Set(0)
-			metrics.HealthCheckErrors.WithLabelValues("etcd", "health_check_failed").Inc()
-			return
-		}
-
-		log.Printf("Etcd is healthy (verified via API Server)")
-		metrics.EtcdUp.WithLabelValues().Set(1)
-		return
+		return c.checkEtcdViaAPIServer(ctx, result)
 	}
 
 	// Check if at least one etcd pod is running
@@ -225,79 +268,86 @@ func (c *KubernetesCollector) checkEtcd() {
 	}
 
 	if !hasRunningPod {
-		log.Printf("No running etcd pods found")
-		metrics.EtcdUp.WithLabelValues().Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("etcd", "no_running_pods").Inc()
-		return
+		return result.down("no_running_pods", "无运行中Pod", "No running etcd pods found")
 	}
 
-	log.Printf("Etcd is healthy")
-	metrics.EtcdUp.WithLabelValues().Set(1)
+	return result.up("Etcd is healthy")
 }
 
-// checkStorageClasses checks if storage classes are available by creating test PVCs
-func (c *KubernetesCollector) checkStorageClasses() {
-	start := time.Now()
-	defer func() {
-		metrics.HealthCheckDuration.WithLabelValues("storage_class").Observe(time.Since(start).Seconds())
-	}()
+// checkEtcdViaAPIServer checks etcd through the etcd readiness check of the API Server
+func (c *KubernetesCollector) checkEtcdViaAPIServer(ctx context.Context, result CheckResult) CheckResult {
+	req := c.clientset.Discovery().RESTClient().Get().AbsPath("/readyz/etcd")
+	if err := req.Do(ctx).Error(); err != nil {
+		return result.down("health_check_failed", classifyK8sError(err),
+			fmt.Sprintf("Etcd health check via API Server failed: %v", err))
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+	return result.up("Etcd is healthy (verified via API Server)")
+}
+
+// checkStorageClasses checks if storage classes are available by creating test PVCs
+func (c *KubernetesCollector) checkStorageClasses(ctx context.Context) []CheckResult {
+	// Failures before any storage class is known are reported against "default"
+	result := newStorageClassResult("default")
 
 	// List all storage classes
 	storageClasses, err := c.clientset.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		errorReason := classifyK8sError(err)
-		log.Printf("Failed to list storage classes: %v [reason: %s]", err, errorReason)
-		metrics.ClusterStorageUp.WithLabelValues("default").Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("storage_class", "list_failed").Inc()
-		return
+		// The storage classes checked before are not gone, they are reported down rather than deleted
+		result.AllTargets = true
+		return []CheckResult{result.down("list_failed", classifyK8sError(err),
+			fmt.Sprintf("Failed to list storage classes: %v", err))}
 	}
 
 	if len(storageClasses.Items) == 0 {
-		log.Printf("No storage classes found")
-		metrics.ClusterStorageUp.WithLabelValues("default").Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("storage_class", "no_storage_classes").Inc()
-		return
+		return []CheckResult{result.down("no_storage_classes", "未找到存储类", "No storage classes found")}
 	}
 
-	// Check each storage class by creating a test PVC
-	for _, sc := range storageClasses.Items {
-		go c.testStorageClass(sc.Name)
+	// Check each storage class by creating a test PVC, in parallel within the check timeout
+	results := make([]CheckResult, len(storageClasses.Items))
+	var wg sync.WaitGroup
+	for i, sc := range storageClasses.Items {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i] = c.testStorageClass(ctx, name)
+		}(i, sc.Name)
 	}
+	wg.Wait()
+	return results
+}
+
+// newStorageClassResult starts a result for a storage class
+func newStorageClassResult(storageClassName string) CheckResult {
+	return newResult("storage_class", storageClassName, map[string]string{"storage_class": storageClassName})
 }
 
 // testStorageClass tests if a storage class is functional by creating a test PVC
-func (c *KubernetesCollector) testStorageClass(storageClassName string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
-	defer cancel()
+func (c *KubernetesCollector) testStorageClass(ctx context.Context, storageClassName string) CheckResult {
+	result := newStorageClassResult(storageClassName)
 
 	// Get storage class to check binding mode
 	sc, err := c.clientset.StorageV1().StorageClasses().Get(ctx, storageClassName, metav1.GetOptions{})
 	if err != nil {
-		errorReason := classifyK8sError(err)
-		log.Printf("Failed to get storage class %s: %v [reason: %s]", storageClassName, err, errorReason)
-		metrics.ClusterStorageUp.WithLabelValues(storageClassName).Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("storage_class", "get_failed").Inc()
-		return
+		return result.down("get_failed", classifyK8sError(err),
+			fmt.Sprintf("Failed to get storage class %s: %v", storageClassName, err))
 	}
 
 	// Check if it's WaitForFirstConsumer binding mode
 	isWaitForFirstConsumer := sc.VolumeBindingMode != nil && *sc.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer
 
-	// Generate unique test PVC name
-	testPVCName := fmt.Sprintf("health-check-test-%s-%d", storageClassName, time.Now().Unix())
-	namespace := "rbd-system" // Use rbd-system namespace for health checks
+	// The API server makes the test PVC name unique, two collectors may test a storage class at once
+	namespace := c.profile.ProbeNamespace
 
 	// Create test PVC
 	pvc := &corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      testPVCName,
-			Namespace: namespace,
+			GenerateName: fmt.Sprintf("health-check-test-%s-", storageClassName),
+			Namespace:    namespace,
 			Labels: map[string]string{
-				"app":     "health-console",
-				"purpose": "storage-test",
+				"app":               "health-console",
+				"purpose":           "storage-test",
+				testPVCProcessLabel: processID,
 			},
 		},
 		Spec: corev1.PersistentVolumeClaimSpec{
@@ -311,32 +361,31 @@ func (c *KubernetesCollector) testStorageClass(storageClassName string) {
 		},
 	}
 
-	if isWaitForFirstConsumer {
-		log.Printf("Testing storage class %s (WaitForFirstConsumer mode) by creating test PVC %s...", storageClassName, testPVCName)
-	} else {
-		log.Printf("Testing storage class %s by creating test PVC %s...", storageClassName, testPVCName)
-	}
-
 	// Create the test PVC
-	_, err = c.clientset.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, metav1.CreateOptions{})
+	created, err := c.clientset.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, metav1.CreateOptions{})
 	if err != nil {
-		errorReason := classifyK8sError(err)
-		log.Printf("Failed to create test PVC for storage class %s: %v [reason: %s]", storageClassName, err, errorReason)
-		metrics.ClusterStorageUp.WithLabelValues(storageClassName).Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("storage_class", "pvc_create_failed").Inc()
-		return
+		return result.down("pvc_create_failed", classifyK8sError(err),
+			fmt.Sprintf("Failed to create test PVC for storage class %s: %v", storageClassName, err))
+	}
+	testPVCName := created.Name
+	c.mu.Lock()
+	c.pvcs[testPVCName] = true
+	c.mu.Unlock()
+
+	if isWaitForFirstConsumer {
+		log.Printf("Testing storage class %s (WaitForFirstConsumer mode) with test PVC %s...", storageClassName, testPVCName)
+	} else {
+		log.Printf("Testing storage class %s with test PVC %s...", storageClassName, testPVCName)
 	}
 
 	// Ensure cleanup on exit
 	defer func() {
 		// Delete the test PVC (the PV will be automatically deleted if dynamically provisioned)
-		deleteCtx, deleteCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		// Cleanup must still run when the check was cancelled by shutdown or timed out
+		deleteCtx, deleteCancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
 		defer deleteCancel()
 
-		err := c.clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(deleteCtx, testPVCName, metav1.DeleteOptions{})
-		if err != nil {
-			log.Printf("Warning: Failed to delete test PVC %s: %v", testPVCName, err)
-		} else {
+		if err := c.deleteTestPVC(deleteCtx, testPVCName); err == nil {
 			log.Printf("Cleaned up test PVC %s for storage class %s", testPVCName, storageClassName)
 		}
 	}()
@@ -345,36 +394,32 @@ func (c *KubernetesCollector) testStorageClass(storageClassName string) {
 	// and is in Pending state (waiting for a Pod to consume it)
 	if isWaitForFirstConsumer {
 		// Wait a bit to ensure PVC status is updated
-		time.Sleep(2 * time.Second)
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return result.down("context_cancelled", "检查被取消",
+				fmt.Sprintf("Context cancelled while testing storage class %s", storageClassName))
+		}
 
 		currentPVC, err := c.clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, testPVCName, metav1.GetOptions{})
 		if err != nil {
-			errorReason := classifyK8sError(err)
-			log.Printf("Failed to get test PVC %s status: %v [reason: %s]", testPVCName, err, errorReason)
-			metrics.ClusterStorageUp.WithLabelValues(storageClassName).Set(0)
-			metrics.HealthCheckErrors.WithLabelValues("storage_class", "pvc_get_failed").Inc()
-			return
+			return result.down("pvc_get_failed", classifyK8sError(err),
+				fmt.Sprintf("Failed to get test PVC %s status: %v", testPVCName, err))
 		}
 
 		// For WaitForFirstConsumer, Pending is the expected state
 		if currentPVC.Status.Phase == corev1.ClaimPending {
-			log.Printf("Test PVC %s is Pending (WaitForFirstConsumer), storage class %s is functional", testPVCName, storageClassName)
-			metrics.ClusterStorageUp.WithLabelValues(storageClassName).Set(1)
-			return
+			return result.up(fmt.Sprintf("Test PVC %s is Pending (WaitForFirstConsumer), storage class %s is functional", testPVCName, storageClassName))
 		}
 
 		// If it somehow got bound (rare but possible), that's also good
 		if currentPVC.Status.Phase == corev1.ClaimBound {
-			log.Printf("Test PVC %s is Bound, storage class %s is functional", testPVCName, storageClassName)
-			metrics.ClusterStorageUp.WithLabelValues(storageClassName).Set(1)
-			return
+			return result.up(fmt.Sprintf("Test PVC %s is Bound, storage class %s is functional", testPVCName, storageClassName))
 		}
 
 		// Any other state is problematic
-		log.Printf("Test PVC %s in unexpected state %s for WaitForFirstConsumer storage class", testPVCName, currentPVC.Status.Phase)
-		metrics.ClusterStorageUp.WithLabelValues(storageClassName).Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("storage_class", "unexpected_state").Inc()
-		return
+		return result.down("unexpected_state", "PVC状态异常",
+			fmt.Sprintf("Test PVC %s in unexpected state %s for WaitForFirstConsumer storage class", testPVCName, currentPVC.Status.Phase))
 	}
 
 	// For Immediate binding mode, wait for PVC to become Bound
@@ -382,15 +427,8 @@ func (c *KubernetesCollector) testStorageClass(storageClassName string) {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
-	timeout := time.After(30 * time.Second)
 	for {
 		select {
-		case <-timeout:
-			log.Printf("Timeout waiting for test PVC %s to bind (storage class %s may be slow or unavailable)", testPVCName, storageClassName)
-			metrics.ClusterStorageUp.WithLabelValues(storageClassName).Set(0)
-			metrics.HealthCheckErrors.WithLabelValues("storage_class", "pvc_bind_timeout").Inc()
-			return
-
 		case <-ticker.C:
 			// Check PVC status
 			currentPVC, err := c.clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, testPVCName, metav1.GetOptions{})
@@ -401,27 +439,27 @@ func (c *KubernetesCollector) testStorageClass(storageClassName string) {
 
 			// Check if PVC is Bound
 			if currentPVC.Status.Phase == corev1.ClaimBound {
-				log.Printf("Test PVC %s successfully bound! Storage class %s is functional", testPVCName, storageClassName)
-				metrics.ClusterStorageUp.WithLabelValues(storageClassName).Set(1)
-				return
+				return result.up(fmt.Sprintf("Test PVC %s successfully bound! Storage class %s is functional", testPVCName, storageClassName))
 			}
 
 			// Check if PVC is in a failed state
 			if currentPVC.Status.Phase == corev1.ClaimLost {
-				log.Printf("Test PVC %s is in Lost state, storage class %s may have issues", testPVCName, storageClassName)
-				metrics.ClusterStorageUp.WithLabelValues(storageClassName).Set(0)
-				metrics.HealthCheckErrors.WithLabelValues("storage_class", "pvc_lost").Inc()
-				return
+				return result.down("pvc_lost", "PVC丢失",
+					fmt.Sprintf("Test PVC %s is in Lost state, storage class %s may have issues", testPVCName, storageClassName))
 			}
 
 			// Log current status
 			log.Printf("Test PVC %s status: %s (waiting for Bound)", testPVCName, currentPVC.Status.Phase)
 
 		case <-ctx.Done():
-			log.Printf("Context cancelled while testing storage class %s", storageClassName)
-			metrics.ClusterStorageUp.WithLabelValues(storageClassName).Set(0)
-			metrics.HealthCheckErrors.WithLabelValues("storage_class", "context_cancelled").Inc()
-			return
+			// The check timeout bounds how long we wait for the PVC to bind
+			if ctx.Err() == context.DeadlineExceeded {
+				return result.down("pvc_bind_timeout", "PVC绑定超时",
+					fmt.Sprintf("Timeout waiting for test PVC %s to bind (storage class %s may be slow or unavailable)", testPVCName, storageClassName))
+			}
+
+			return result.down("context_cancelled", "检查被取消",
+				fmt.Sprintf("Context cancelled while testing storage class %s", storageClassName))
 		}
 	}
 }
@@ -512,3 +550,12 @@ func classifyDNSError(err error) string {
 	// Generic DNS error
 	return "DNS解析失败"
 }
+
+// newProcessID returns a random identifier of this process, usable as a label value
+func newProcessID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,224 @@
+package collectors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rainbond/health-console/config"
+	"github.com/rainbond/health-console/metrics"
+)
+
+// pluginWaitDelay bounds how long a killed plugin may keep its output pipes open
+const pluginWaitDelay = 5 * time.Second
+
+// pluginReasons are the reasons a plugin may report, those of the built-in checks, so that the
+// reason label of health_check_failure_reason keeps a bounded set of values
+var pluginReasons = map[string]bool{
+	"连接超时":    true,
+	"连接被拒绝":   true,
+	"连接被重置":   true,
+	"网络不可达":   true,
+	"DNS解析失败": true,
+	"TLS证书错误": true,
+	"认证失败":    true,
+	"权限不足":    true,
+	"资源不存在":   true,
+	"请求超时":    true,
+	"服务不可用":   true,
+	"服务内部错误":  true,
+	"磁盘空间不足":  true,
+	"数据库只读":   true,
+}
+
+// pluginResult is the JSON document a plugin prints to stdout
+type pluginResult struct {
+	Status  string             `json:"status"`
+	Reason  string             `json:"reason"`
+	Message string             `json:"message"`
+	Values  map[string]float64 `json:"values"`
+}
+
+// PluginCollector runs configured external executables as health checks
+type PluginCollector struct {
+	plugins   []config.PluginConfig
+	maxOutput int
+
+	// slots limits how many plugins run at the same time
+	slots chan struct{}
+}
+
+// NewPluginCollector creates a new plugin collector
+func NewPluginCollector(cfg *config.Config) *PluginCollector {
+	concurrency := cfg.PluginMaxConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &PluginCollector{
+		plugins:   cfg.Plugins,
+		maxOutput: cfg.PluginMaxOutputBytes,
+		slots:     make(chan struct{}, concurrency),
+	}
+}
+
+// Name returns the collector name
+func (c *PluginCollector) Name() string {
+	return "plugin"
+}
+
+// Checks returns one check per configured plugin
+func (c *PluginCollector) Checks() []Check {
+	checks := make([]Check, 0, len(c.plugins))
+	for _, plugin := range c.plugins {
+		plugin := plugin
+		check := newCheck("plugin/"+plugin.Name, plugin.Check, metrics.PluginUp, single(func(ctx context.Context) CheckResult {
+			return c.checkPlugin(ctx, plugin)
+		}))
+		check.ValueMetric = metrics.PluginValue
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// Start begins running plugins
+func (c *PluginCollector) Start(ctx context.Context) error {
+	log.Println("Starting plugin collector...")
+	return nil
+}
+
+// Stop stops the collector
+func (c *PluginCollector) Stop(ctx context.Context) error {
+	log.Println("Stopping plugin collector...")
+	return nil
+}
+
+// checkPlugin runs a plugin once and converts its JSON output into a result
+func (c *PluginCollector) checkPlugin(ctx context.Context, plugin config.PluginConfig) CheckResult {
+	result := newResult("plugin", plugin.Name, map[string]string{"instance": plugin.Name})
+
+	// Wait for a free slot, the wait counts against the check timeout
+	select {
+	case c.slots <- struct{}{}:
+		defer func() { <-c.slots }()
+	case <-ctx.Done():
+		return result.down("concurrency_limited", "并发数已满",
+			fmt.Sprintf("Plugin %s did not get a run slot: %v", plugin.Name, ctx.Err()))
+	}
+
+	// A plugin flooding stdout is killed as soon as it exceeds the output limit
+	runCtx, kill := context.WithCancel(ctx)
+	defer kill()
+
+	stdout := &limitedBuffer{limit: c.maxOutput, overflow: kill}
+	stderr := &limitedBuffer{limit: c.maxOutput}
+	cmd := exec.CommandContext(runCtx, plugin.Command, plugin.Args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = pluginWaitDelay
+
+	runErr := cmd.Run()
+
+	if stdout.truncated {
+		return result.down("output_too_large", "输出过大",
+			fmt.Sprintf("Plugin %s wrote more than %d bytes to stdout", plugin.Name, c.maxOutput))
+	}
+	if ctx.Err() != nil {
+		return result.down("timeout", "执行超时",
+			fmt.Sprintf("Plugin %s did not finish in time: %v", plugin.Name, ctx.Err()))
+	}
+
+	var out pluginResult
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		if runErr != nil {
+			message := fmt.Sprintf("Plugin %s failed: %v", plugin.Name, runErr)
+			if detail := strings.TrimSpace(stderr.String()); detail != "" {
+				message += ": " + detail
+			}
+			return result.down("exec_failed", classifyPluginError(runErr), message)
+		}
+		return result.down("invalid_output", "输出格式错误",
+			fmt.Sprintf("Plugin %s printed invalid JSON: %v", plugin.Name, err))
+	}
+
+	result.Values = out.Values
+	message := out.Message
+	if message == "" {
+		message = "status " + out.Status
+	}
+
+	switch strings.ToLower(out.Status) {
+	case string(StatusUp):
+		return result.up(fmt.Sprintf("Plugin %s: %s", plugin.Name, message))
+	case string(StatusDown):
+		// Any other reason goes in the message
+		reason := strings.TrimSpace(out.Reason)
+		if !pluginReasons[reason] {
+			if reason != "" {
+				message += fmt.Sprintf(" (reason: %s)", reason)
+			}
+			reason = "插件报告异常"
+		}
+		return result.down("reported_down", reason, fmt.Sprintf("Plugin %s: %s", plugin.Name, message))
+	default:
+		return result.down("invalid_output", "输出格式错误",
+			fmt.Sprintf("Plugin %s reported unknown status %q", plugin.Name, out.Status))
+	}
+}
+
+// limitedBuffer stores up to limit bytes and discards the rest, so runaway output cannot exhaust memory
+// The buffer is not embedded so io.Copy cannot bypass Write through bytes.Buffer.ReadFrom
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+
+	// overflow is called once when the limit is first exceeded
+	overflow func()
+}
+
+// Write implements io.Writer, always reporting success so the plugin is not killed by a broken pipe
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		if !b.truncated && b.overflow != nil {
+			b.overflow()
+		}
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Bytes returns the buffered output
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// String returns the buffered output as a string
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// classifyPluginError classifies plugin execution errors for better troubleshooting
+func classifyPluginError(err error) string {
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return fmt.Sprintf("退出码%d", exitErr.ExitCode())
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return "命令不存在"
+	case strings.Contains(strings.ToLower(err.Error()), "permission denied"):
+		return "权限不足"
+	default:
+		return "执行失败"
+	}
+}
@@ -0,0 +1,102 @@
+package collectors
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rainbond/health-console/config"
+)
+
+func TestLimitedBuffer(t *testing.T) {
+	tests := []struct {
+		name          string
+		writes        []string
+		want          string
+		wantTruncated bool
+		wantOverflows int
+	}{
+		{name: "under the limit", writes: []string{"ab", "cd"}, want: "abcd"},
+		{name: "exactly the limit", writes: []string{"abc", "def"}, want: "abcdef"},
+		{name: "write crossing the limit", writes: []string{"abcd", "efgh"}, want: "abcdef", wantTruncated: true, wantOverflows: 1},
+		{name: "writes after the limit", writes: []string{"abcdef", "g", "hij"}, want: "abcdef", wantTruncated: true, wantOverflows: 1},
+		{name: "single oversized write", writes: []string{"abcdefghij"}, want: "abcdef", wantTruncated: true, wantOverflows: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overflows := 0
+			b := &limitedBuffer{limit: 6, overflow: func() { overflows++ }}
+			for _, w := range tt.writes {
+				// The plugin must never see a write error, it would die of a broken pipe
+				if n, err := b.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v, want %d, nil", w, n, err, len(w))
+				}
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("buffered %q, want %q", got, tt.want)
+			}
+			if b.truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", b.truncated, tt.wantTruncated)
+			}
+			if overflows != tt.wantOverflows {
+				t.Errorf("overflow called %d times, want %d", overflows, tt.wantOverflows)
+			}
+		})
+	}
+}
+
+func TestCheckPluginKillsFloodingPlugin(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	c := NewPluginCollector(&config.Config{PluginMaxOutputBytes: 1024, PluginMaxConcurrency: 1})
+	plugin := config.PluginConfig{Name: "flood", Command: "sh", Args: []string{"-c", "while :; do echo flooding; done"}}
+
+	// The plugin never ends by itself, only the output limit stops it before the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	r := c.checkPlugin(ctx, plugin)
+	if r.Status != StatusDown || r.ErrorType != "output_too_large" {
+		t.Fatalf("result = %+v, want down with output_too_large", r)
+	}
+	if ctx.Err() != nil {
+		t.Error("plugin was not killed when it exceeded the output limit")
+	}
+	if !strings.Contains(r.Message, "1024 bytes") {
+		t.Errorf("message %q does not name the limit", r.Message)
+	}
+}
+
+func TestCheckPluginBoundsReasons(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	c := NewPluginCollector(&config.Config{PluginMaxOutputBytes: 1024, PluginMaxConcurrency: 1})
+
+	tests := []struct {
+		reason      string
+		wantReason  string
+		wantMessage string
+	}{
+		{reason: "连接超时", wantReason: "连接超时", wantMessage: "Plugin mq: queue stuck"},
+		{reason: " 磁盘空间不足 ", wantReason: "磁盘空间不足", wantMessage: "Plugin mq: queue stuck"},
+		{reason: "", wantReason: "插件报告异常", wantMessage: "Plugin mq: queue stuck"},
+		// A free-form reason would make a new series of health_check_failure_reason per value
+		{reason: "queue depth 1234 over 1000", wantReason: "插件报告异常", wantMessage: "Plugin mq: queue stuck (reason: queue depth 1234 over 1000)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			output := `{"status": "down", "reason": "` + tt.reason + `", "message": "queue stuck"}`
+			plugin := config.PluginConfig{Name: "mq", Command: "sh", Args: []string{"-c", "echo '" + output + "'"}}
+
+			r := c.checkPlugin(context.Background(), plugin)
+			if r.Status != StatusDown || r.ErrorType != "reported_down" || r.Reason != tt.wantReason || r.Message != tt.wantMessage {
+				t.Errorf("result = %+v, want down with reason %q and message %q", r, tt.wantReason, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package collectors
+
+import (
+	"database/sql"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// DatabasePools keeps a connection pool open per database instance, so checks reuse connections
+// instead of paying for a handshake every run. Pools outlive the database collector: a reload
+// keeps the pools of the instances whose settings did not change
+type DatabasePools struct {
+	mu     sync.Mutex
+	pools  map[string]*databasePool
+	owners map[*DatabaseCollector][]string // Instances of the running collectors
+}
+
+// databasePool is the pool of an instance and the settings it was opened with
+type databasePool struct {
+	db  *sql.DB
+	key string
+}
+
+// NewDatabasePools creates an empty set of pools
+func NewDatabasePools() *DatabasePools {
+	return &DatabasePools{
+		pools:  make(map[string]*databasePool),
+		owners: make(map[*DatabaseCollector][]string),
+	}
+}
+
+// get returns the pool of the named instance, opening it with open if there is none or if it was
+// opened with settings other than key, such as a rotated password
+func (p *DatabasePools) get(name, key string, open func() (*sql.DB, error)) (*sql.DB, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pool, ok := p.pools[name]; ok {
+		if pool.key == key {
+			return pool.db, nil
+		}
+		log.Printf("Settings of database %s changed, reopening its connection pool", name)
+		p.close(name)
+	}
+
+	db, err := open()
+	if err != nil {
+		return nil, err
+	}
+	p.pools[name] = &databasePool{db: db, key: key}
+	return db, nil
+}
+
+// attach records the instances a starting collector checks
+func (p *DatabasePools) attach(c *DatabaseCollector, names []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.owners[c] = names
+}
+
+// detach forgets a stopped collector and closes the pools no running collector checks
+// A replacing collector is attached before the replaced one is detached, so the pools of the
+// instances they share stay open
+func (p *DatabasePools) detach(c *DatabaseCollector) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.owners, c)
+	for name := range p.pools {
+		used := false
+		for _, names := range p.owners {
+			used = used || slices.Contains(names, name)
+		}
+		if !used {
+			p.close(name)
+		}
+	}
+}
+
+// close closes the pool of the named instance, connections in use are closed when released, and
+// deregisters the TLS config it was opened with. The caller holds p.mu
+func (p *DatabasePools) close(name string) {
+	if err := p.pools[name].db.Close(); err != nil {
+		log.Printf("Error closing connection pool of database %s: %v", name, err)
+	}
+	delete(p.pools, name)
+	// The connector copied the TLS config when the pool was opened, so the open connections keep it
+	mysql.DeregisterTLSConfig(tlsConfigPrefix + name)
+}
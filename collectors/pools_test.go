@@ -0,0 +1,55 @@
+package collectors
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/rainbond/health-console/config"
+)
+
+func TestDatabasePoolsDeregisterTLSConfigs(t *testing.T) {
+	pools := NewDatabasePools()
+	c := &DatabaseCollector{}
+	pools.attach(c, []string{"tls-db"})
+
+	dbConfig := config.DatabaseConfig{Name: "tls-db", Host: "127.0.0.1", Port: 3306, Check: config.CheckConfig{Timeout: time.Second}}
+	open := func(tlsConfig *tls.Config) func() (*sql.DB, error) {
+		return func() (*sql.DB, error) {
+			return openDatabase(dbConfig, config.DatabasePoolConfig{MaxOpen: 1}, "", tlsConfig)
+		}
+	}
+	// registered tells whether the driver knows the TLS config of the instance, a DSN naming an
+	// unknown config does not parse
+	registered := func() bool {
+		_, err := mysql.ParseDSN("/?tls=" + url.QueryEscape(tlsConfigPrefix+dbConfig.Name))
+		return err == nil
+	}
+
+	if _, err := pools.get(dbConfig.Name, "with-tls", open(&tls.Config{ServerName: "db"})); err != nil {
+		t.Fatal(err)
+	}
+	if !registered() {
+		t.Fatal("TLS config of tls-db is not registered after opening its pool")
+	}
+
+	// Replacing the pool with one without TLS drops the config of the replaced pool
+	if _, err := pools.get(dbConfig.Name, "without-tls", open(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if registered() {
+		t.Error("TLS config of tls-db is still registered after its pool was replaced")
+	}
+
+	if _, err := pools.get(dbConfig.Name, "with-tls", open(&tls.Config{ServerName: "db"})); err != nil {
+		t.Fatal(err)
+	}
+	pools.detach(c)
+	if registered() {
+		t.Error("TLS config of tls-db is still registered after its pool was closed")
+	}
+}
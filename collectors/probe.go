@@ -0,0 +1,302 @@
+package collectors
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/rainbond/health-console/config"
+	"github.com/rainbond/health-console/metrics"
+)
+
+// maxProbeBodySize bounds how much of an HTTP response body is read for assertions
+const maxProbeBodySize = 1 << 20
+
+// ProbeCollector runs config-declared blackbox probes against arbitrary dependencies
+type ProbeCollector struct {
+	probes []config.ProbeConfig
+	// clients are the HTTP clients of the HTTP probes by probe name, reused across runs so that
+	// their keep-alive connections are too
+	clients map[string]*http.Client
+}
+
+// NewProbeCollector creates a new probe collector
+func NewProbeCollector(cfg *config.Config) *ProbeCollector {
+	clients := make(map[string]*http.Client)
+	for _, probe := range cfg.Probes {
+		if probe.Type == config.ProbeHTTP {
+			// The default transport brings the proxy from the environment and the dial and
+			// handshake timeouts
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = probeTLSConfig(probe)
+			clients[probe.Name] = &http.Client{Transport: transport}
+		}
+	}
+	return &ProbeCollector{
+		probes:  cfg.Probes,
+		clients: clients,
+	}
+}
+
+// Name returns the collector name
+func (c *ProbeCollector) Name() string {
+	return "probe"
+}
+
+// Checks returns one check per configured probe
+func (c *ProbeCollector) Checks() []Check {
+	checks := make([]Check, 0, len(c.probes))
+	for _, probe := range c.probes {
+		probe := probe
+		check := newCheck("probe/"+probe.Name, probe.Check, metrics.ProbeUp, single(func(ctx context.Context) CheckResult {
+			return c.checkProbe(ctx, probe)
+		}))
+		check.Source = probe.Source
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// Start begins running probes
+func (c *ProbeCollector) Start(ctx context.Context) error {
+	log.Println("Starting probe collector...")
+	return nil
+}
+
+// Stop stops the collector and closes the idle connections of the HTTP probes
+func (c *ProbeCollector) Stop(ctx context.Context) error {
+	log.Println("Stopping probe collector...")
+	for _, client := range c.clients {
+		client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// checkProbe runs a single probe according to its type
+func (c *ProbeCollector) checkProbe(ctx context.Context, probe config.ProbeConfig) CheckResult {
+	result := newResult("probe", probe.Name, map[string]string{
+		"instance": probe.Name,
+		"type":     probe.Type,
+		"target":   probe.Target,
+	})
+
+	switch probe.Type {
+	case config.ProbeTCP:
+		return probeTCP(ctx, probe, result)
+	case config.ProbeHTTP:
+		return probeHTTP(ctx, c.clients[probe.Name], probe, result)
+	case config.ProbeDNS:
+		return probeDNS(ctx, probe, result)
+	case config.ProbeTLS:
+		return probeTLS(ctx, probe, result)
+	default:
+		return result.down("invalid_type", "探测类型不支持",
+			fmt.Sprintf("Probe %s has unsupported type %q", probe.Name, probe.Type))
+	}
+}
+
+// probeTCP checks that a TCP connection can be established
+func probeTCP(ctx context.Context, probe config.ProbeConfig, result CheckResult) CheckResult {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", probe.Target)
+	if err != nil {
+		return result.down("connect_failed", classifyProbeError(err),
+			fmt.Sprintf("Probe %s: TCP connect to %s failed: %v", probe.Name, probe.Target, err))
+	}
+	conn.Close()
+
+	return result.up(fmt.Sprintf("Probe %s: TCP %s is reachable", probe.Name, probe.Target))
+}
+
+// probeTLS checks that a TLS handshake succeeds and the certificate verifies
+func probeTLS(ctx context.Context, probe config.ProbeConfig, result CheckResult) CheckResult {
+	dialer := tls.Dialer{Config: probeTLSConfig(probe)}
+	conn, err := dialer.DialContext(ctx, "tcp", probe.Target)
+	if err != nil {
+		return result.down("handshake_failed", classifyProbeError(err),
+			fmt.Sprintf("Probe %s: TLS handshake with %s failed: %v", probe.Name, probe.Target, err))
+	}
+	conn.Close()
+
+	return result.up(fmt.Sprintf("Probe %s: TLS handshake with %s succeeded", probe.Name, probe.Target))
+}
+
+// probeHTTP checks an HTTP(S) endpoint against the expected status, body and headers
+func probeHTTP(ctx context.Context, client *http.Client, probe config.ProbeConfig, result CheckResult) CheckResult {
+	req, err := http.NewRequestWithContext(ctx, probe.Method, probe.Target, nil)
+	if err != nil {
+		return result.down("request_failed", "请求创建失败",
+			fmt.Sprintf("Probe %s: failed to create request for %s: %v", probe.Name, probe.Target, err))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return result.down("unreachable", classifyProbeError(err),
+			fmt.Sprintf("Probe %s: %s is unreachable: %v", probe.Name, probe.Target, err))
+	}
+	defer resp.Body.Close()
+
+	if !expectedStatus(probe.ExpectedStatus, resp.StatusCode) {
+		return result.down("unexpected_status", classifyHTTPStatus(resp.StatusCode),
+			fmt.Sprintf("Probe %s: %s returned unexpected status: %d", probe.Name, probe.Target, resp.StatusCode))
+	}
+
+	for name, want := range probe.ExpectedHeaders {
+		if got := resp.Header.Get(name); got == "" || !strings.Contains(got, want) {
+			return result.down("header_mismatch", "响应头不匹配",
+				fmt.Sprintf("Probe %s: %s header %s is %q, expected it to contain %q", probe.Name, probe.Target, name, got, want))
+		}
+	}
+
+	if probe.ExpectedBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBodySize))
+		if err != nil {
+			return result.down("body_read_failed", classifyProbeError(err),
+				fmt.Sprintf("Probe %s: failed to read response body from %s: %v", probe.Name, probe.Target, err))
+		}
+		if !strings.Contains(string(body), probe.ExpectedBody) {
+			return result.down("body_mismatch", "响应内容不匹配",
+				fmt.Sprintf("Probe %s: %s response body does not contain %q", probe.Name, probe.Target, probe.ExpectedBody))
+		}
+	}
+
+	return result.up(fmt.Sprintf("Probe %s: %s is healthy (status %d)", probe.Name, probe.Target, resp.StatusCode))
+}
+
+// probeDNS resolves a name and checks that every expected answer is returned
+func probeDNS(ctx context.Context, probe config.ProbeConfig, result CheckResult) CheckResult {
+	resolver := net.DefaultResolver
+	if probe.DNSServer != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, probe.DNSServer)
+			},
+		}
+	}
+
+	answers, err := lookup(ctx, resolver, probe.RecordType, probe.Target)
+	if err != nil {
+		return result.down("resolution_failed", classifyDNSError(err),
+			fmt.Sprintf("Probe %s: resolving %s %s failed: %v", probe.Name, probe.RecordType, probe.Target, err))
+	}
+
+	for _, want := range probe.ExpectedAnswers {
+		if !slices.Contains(answers, strings.TrimSuffix(want, ".")) {
+			return result.down("answer_mismatch", "DNS解析结果不匹配",
+				fmt.Sprintf("Probe %s: %s %s resolved to %v, missing expected answer %s", probe.Name, probe.RecordType, probe.Target, answers, want))
+		}
+	}
+
+	return result.up(fmt.Sprintf("Probe %s: %s %s resolved to %v", probe.Name, probe.RecordType, probe.Target, answers))
+}
+
+// lookup resolves host for the given record type, returning answers without trailing dots
+func lookup(ctx context.Context, resolver *net.Resolver, recordType, host string) ([]string, error) {
+	var answers []string
+	switch recordType {
+	case "A", "AAAA":
+		network := "ip4"
+		if recordType == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := resolver.LookupIP(ctx, network, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			answers = append(answers, ip.String())
+		}
+	case "CNAME":
+		cname, err := resolver.LookupCNAME(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, cname)
+	case "TXT":
+		txts, err := resolver.LookupTXT(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, txts...)
+	case "MX":
+		mxs, err := resolver.LookupMX(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, mx := range mxs {
+			answers = append(answers, mx.Host)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported DNS record type %q", recordType)
+	}
+
+	for i := range answers {
+		answers[i] = strings.TrimSuffix(answers[i], ".")
+	}
+	return answers, nil
+}
+
+// probeTLSConfig builds the TLS client configuration of a probe
+func probeTLSConfig(probe config.ProbeConfig) *tls.Config {
+	return &tls.Config{
+		ServerName:         probe.ServerName,
+		InsecureSkipVerify: probe.Insecure,
+	}
+}
+
+// classifyProbeError classifies the TCP, TLS and HTTP errors of a probe for better troubleshooting
+func classifyProbeError(err error) string {
+	errMsg := strings.ToLower(err.Error())
+
+	switch {
+	// TLS errors, checked first as they may also mention the connection
+	case strings.Contains(errMsg, "certificate signed by unknown authority"):
+		return "证书由未知CA签发"
+	case strings.Contains(errMsg, "certificate has expired") || strings.Contains(errMsg, "not yet valid"):
+		return "证书已过期"
+	case strings.Contains(errMsg, "certificate is valid for") || strings.Contains(errMsg, "certificate is not valid for"):
+		return "证书与主机名不匹配"
+	case strings.Contains(errMsg, "does not look like a tls handshake") || strings.Contains(errMsg, "http response to https client"):
+		return "目标不是TLS服务"
+	case strings.Contains(errMsg, "x509") || strings.Contains(errMsg, "tls:") || strings.Contains(errMsg, "certificate"):
+		return "TLS握手失败"
+
+	// Network errors
+	case strings.Contains(errMsg, "timeout") || strings.Contains(errMsg, "deadline exceeded"):
+		return "连接超时"
+	case strings.Contains(errMsg, "connection refused"):
+		return "连接被拒绝"
+	case strings.Contains(errMsg, "no route to host") || strings.Contains(errMsg, "network is unreachable"):
+		return "网络不可达"
+	case strings.Contains(errMsg, "connection reset"):
+		return "连接被重置"
+	case strings.Contains(errMsg, "no such host") || strings.Contains(errMsg, "server misbehaving"):
+		return "DNS解析失败"
+
+	// HTTP errors
+	case strings.Contains(errMsg, "stopped after") && strings.Contains(errMsg, "redirects"):
+		return "重定向次数过多"
+	case strings.Contains(errMsg, "malformed http response") || strings.Contains(errMsg, "unsupported protocol scheme"):
+		return "HTTP协议错误"
+	case strings.Contains(errMsg, "eof"):
+		return "连接意外关闭"
+	default:
+		return "未知错误"
+	}
+}
+
+// expectedStatus reports whether status is accepted, any 2xx if no status is configured
+func expectedStatus(expected []int, status int) bool {
+	if len(expected) == 0 {
+		return status >= 200 && status < 300
+	}
+	return slices.Contains(expected, status)
+}
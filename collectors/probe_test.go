@@ -0,0 +1,89 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rainbond/health-console/config"
+)
+
+func TestProbeHTTPReusesConnections(t *testing.T) {
+	var conns atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	probe := config.ProbeConfig{Name: "web", Type: config.ProbeHTTP, Target: server.URL, Method: http.MethodGet, ExpectedBody: "ok"}
+	c := NewProbeCollector(&config.Config{Probes: []config.ProbeConfig{probe}})
+	for range 3 {
+		if r := c.checkProbe(context.Background(), probe); r.Status != StatusUp {
+			t.Fatalf("probe = %+v, want up", r)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("probe runs opened %d connections, want 1", n)
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProbeHTTPUnexpectedStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	probe := config.ProbeConfig{Name: "web", Type: config.ProbeHTTP, Target: server.URL, Method: http.MethodGet}
+	c := NewProbeCollector(&config.Config{Probes: []config.ProbeConfig{probe}})
+	defer c.Stop(context.Background())
+
+	// The status goes in the message, the error type stays one of a fixed set
+	r := c.checkProbe(context.Background(), probe)
+	if r.Status != StatusDown || r.ErrorType != "unexpected_status" || !strings.Contains(r.Message, "503") {
+		t.Errorf("probe = %+v, want down with unexpected_status and the status in the message", r)
+	}
+
+	transport := c.clients["web"].Transport.(*http.Transport)
+	if transport.Proxy == nil || transport.TLSHandshakeTimeout == 0 || transport.DialContext == nil {
+		t.Errorf("probe transport has no proxy, handshake timeout or dialer, want those of the default transport")
+	}
+}
+
+func TestClassifyProbeError(t *testing.T) {
+	tests := []struct {
+		err  string
+		want string
+	}{
+		{"dial tcp 10.0.0.1:443: connect: connection refused", "连接被拒绝"},
+		{"dial tcp 10.0.0.1:443: i/o timeout", "连接超时"},
+		{"dial tcp: lookup web.example.com: no such host", "DNS解析失败"},
+		{"dial tcp 10.0.0.1:443: connect: no route to host", "网络不可达"},
+		{"tls: failed to verify certificate: x509: certificate signed by unknown authority", "证书由未知CA签发"},
+		{"tls: failed to verify certificate: x509: certificate has expired or is not yet valid", "证书已过期"},
+		{"tls: failed to verify certificate: x509: certificate is valid for a.example.com, not b.example.com", "证书与主机名不匹配"},
+		{"tls: first record does not look like a TLS handshake", "目标不是TLS服务"},
+		{"remote error: tls: handshake failure", "TLS握手失败"},
+		{`Get "https://web.example.com": stopped after 10 redirects`, "重定向次数过多"},
+		{`Get "http://web.example.com": EOF`, "连接意外关闭"},
+		{"something else", "未知错误"},
+	}
+	for _, tt := range tests {
+		if got := classifyProbeError(errors.New(tt.err)); got != tt.want {
+			t.Errorf("classifyProbeError(%q) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
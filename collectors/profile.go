@@ -0,0 +1,90 @@
+package collectors
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/rainbond/health-console/config"
+)
+
+// profileDetectionTimeout bounds the API requests of profile detection
+const profileDetectionTimeout = 10 * time.Second
+
+// versionMarkers identify distributions by a marker in the server git version, e.g. v1.28.4+rke2r1
+var versionMarkers = []struct {
+	marker  string
+	profile string
+}{
+	{"+rke2", "rke2"},
+	{"+k3s", "k3s"},
+	{"-eks-", "eks"},
+	{"-aliyun", "ack"},
+	{"-tke.", "tke"},
+}
+
+// nodeLabels identify distributions by a label their nodes carry
+var nodeLabels = []struct {
+	label   string
+	value   string // Any value if empty
+	profile string
+}{
+	{"node.kubernetes.io/instance-type", "rke2", "rke2"},
+	{"node.kubernetes.io/instance-type", "k3s", "k3s"},
+	{"eks.amazonaws.com/nodegroup", "", "eks"},
+	{"eks.amazonaws.com/compute-type", "", "eks"},
+	{"alibabacloud.com/nodepool-id", "", "ack"},
+	{"tke.cloud.tencent.com/nodepool-id", "", "tke"},
+	{"cloud.tencent.com/node-instance-id", "", "tke"},
+}
+
+// detectProfile picks the profile of the cluster distribution from the server version and the
+// node labels, falling back to config.DefaultProfile
+func detectProfile(clientset kubernetes.Interface) string {
+	ctx, cancel := context.WithTimeout(context.Background(), profileDetectionTimeout)
+	defer cancel()
+
+	raw, err := clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		log.Printf("Warning: Failed to get server version for profile detection: %v", err)
+	} else {
+		var info version.Info
+		if err := json.Unmarshal(raw, &info); err == nil {
+			for _, m := range versionMarkers {
+				if strings.Contains(info.GitVersion, m.marker) {
+					return m.profile
+				}
+			}
+		}
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{Limit: 20})
+	if err != nil {
+		log.Printf("Warning: Failed to list nodes for profile detection, using the %s profile: %v", config.DefaultProfile, err)
+		return config.DefaultProfile
+	}
+	for _, node := range nodes.Items {
+		for _, l := range nodeLabels {
+			if value, ok := node.Labels[l.label]; ok && (l.value == "" || value == l.value) {
+				return l.profile
+			}
+		}
+	}
+	return config.DefaultProfile
+}
+
+// resolveProfile returns the name and settings of the configured profile, detecting it if needed
+func resolveProfile(cfg config.KubernetesConfig, clientset kubernetes.Interface) (string, config.ClusterProfile) {
+	name := cfg.Profile
+	if name == config.ProfileAuto {
+		name = detectProfile(clientset)
+		log.Printf("Detected Kubernetes distribution profile %s", name)
+	}
+	return name, config.Profiles[name].WithOverrides(cfg.Overrides)
+}
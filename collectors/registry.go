@@ -7,7 +7,6 @@ import (
 	"log"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/rainbond/health-console/config"
 	"github.com/rainbond/health-console/metrics"
@@ -16,78 +15,58 @@ import (
 // RegistryCollector monitors container registry health
 type RegistryCollector struct {
 	registries []config.RegistryConfig
-	interval   time.Duration
-	ctx        context.Context
-	cancel     context.CancelFunc
+	secrets    *SecretResolver
 }
 
 // NewRegistryCollector creates a new registry collector
-func NewRegistryCollector(cfg *config.Config) *RegistryCollector {
-	ctx, cancel := context.WithCancel(context.Background())
+func NewRegistryCollector(cfg *config.Config, secrets *SecretResolver) *RegistryCollector {
 	return &RegistryCollector{
 		registries: cfg.Registries,
-		interval:   cfg.CollectInterval,
-		ctx:        ctx,
-		cancel:     cancel,
+		secrets:    secrets,
 	}
 }
 
-// Start begins collecting registry metrics
-func (c *RegistryCollector) Start() {
-	log.Println("Starting registry collector...")
-
-	// Initial check
-	c.collect()
-
-	// Periodic checks
-	ticker := time.NewTicker(c.interval)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				c.collect()
-			case <-c.ctx.Done():
-				ticker.Stop()
-				return
-			}
-		}
-	}()
+// Name returns the collector name
+func (c *RegistryCollector) Name() string {
+	return "registry"
 }
 
-// Stop stops the collector
-func (c *RegistryCollector) Stop() {
-	log.Println("Stopping registry collector...")
-	c.cancel()
+// Checks returns one check per configured registry instance
+// Registry hosts are resolved through the cluster DNS, so every check depends on CoreDNS
+func (c *RegistryCollector) Checks() []Check {
+	checks := make([]Check, 0, len(c.registries))
+	for _, registry := range c.registries {
+		registry := registry
+		check := newCheck("registry/"+registry.Name, registry.Check, metrics.RegistryUp, single(func(ctx context.Context) CheckResult {
+			return c.checkRegistry(ctx, registry)
+		}))
+		check.DependsOn = []string{coreDNSCheck}
+		check.Source = registry.Source
+		checks = append(checks, check)
+	}
+	return checks
 }
 
-// collect performs registry health checks
-func (c *RegistryCollector) collect() {
-	for _, registry := range c.registries {
-		go c.checkRegistry(registry)
-	}
+// Start begins collecting registry metrics
+func (c *RegistryCollector) Start(ctx context.Context) error {
+	log.Println("Starting registry collector...")
+	return nil
 }
 
-// setRegistryMetric 更新 registry_up 指标
-func (c *RegistryCollector) setRegistryMetric(name, url string, value float64) {
-	metrics.RegistryUp.WithLabelValues(name, url).Set(value)
+// Stop stops the collector
+func (c *RegistryCollector) Stop(ctx context.Context) error {
+	log.Println("Stopping registry collector...")
+	return nil
 }
 
 // checkRegistry checks a single registry instance
-func (c *RegistryCollector) checkRegistry(regConfig config.RegistryConfig) {
-	start := time.Now()
-	defer func() {
-		metrics.HealthCheckDuration.WithLabelValues("registry").Observe(time.Since(start).Seconds())
-	}()
+func (c *RegistryCollector) checkRegistry(ctx context.Context, regConfig config.RegistryConfig) CheckResult {
+	result := newResult("registry", regConfig.Name, map[string]string{
+		"instance": regConfig.Name,
+		"url":      regConfig.URL,
+	})
 
-	// Normalize URL
-	url := regConfig.URL
-	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
-		if regConfig.Insecure {
-			url = "http://" + url
-		} else {
-			url = "https://" + url
-		}
-	}
+	url := registryBaseURL(regConfig)
 
 	// Ensure URL ends with /v2/ for Docker Registry API
 	if !strings.HasSuffix(url, "/") {
@@ -97,10 +76,8 @@ func (c *RegistryCollector) checkRegistry(regConfig config.RegistryConfig) {
 		url += "v2/"
 	}
 
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
+	// Create HTTP client, the request is bounded by the check timeout
+	client := &http.Client{}
 
 	// Allow insecure TLS if configured
 	if regConfig.Insecure {
@@ -110,42 +87,54 @@ func (c *RegistryCollector) checkRegistry(regConfig config.RegistryConfig) {
 	}
 
 	// Create request
-	req, err := http.NewRequestWithContext(context.Background(), "GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		errorReason := "请求创建失败"
-		log.Printf("Failed to create request for registry %s (%s): %v [reason: %s]", regConfig.Name, regConfig.URL, err, errorReason)
-		c.setRegistryMetric(regConfig.Name, regConfig.URL, 0)
-		metrics.HealthCheckErrors.WithLabelValues("registry", "request_failed").Inc()
-		return
+		return result.down("request_failed", "请求创建失败",
+			fmt.Sprintf("Failed to create request for registry %s (%s): %v", regConfig.Name, regConfig.URL, err))
 	}
 
 	// Add authentication if provided
-	if regConfig.Username != "" && regConfig.Password != "" {
-		req.SetBasicAuth(regConfig.Username, regConfig.Password)
+	password, err := c.secrets.Resolve(ctx, regConfig.Password)
+	if err != nil {
+		return credentialsUnavailable(result, "registry "+regConfig.Name, err)
+	}
+	if regConfig.Username != "" && password != "" {
+		req.SetBasicAuth(regConfig.Username, password)
 	}
 
 	// Perform request
 	resp, err := client.Do(req)
 	if err != nil {
-		errorReason := classifyRegistryError(err)
-		log.Printf("Registry %s (%s) is unreachable: %v [reason: %s]", regConfig.Name, regConfig.URL, err, errorReason)
-		c.setRegistryMetric(regConfig.Name, regConfig.URL, 0)
-		metrics.HealthCheckErrors.WithLabelValues("registry", "unreachable").Inc()
-		return
+		return result.down("unreachable", classifyRegistryError(err),
+			fmt.Sprintf("Registry %s (%s) is unreachable: %v", regConfig.Name, regConfig.URL, err))
 	}
 	defer resp.Body.Close()
 
 	// Check response status
 	// Docker Registry API v2 should return 200 or 401 (authentication required)
-	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusUnauthorized {
-		log.Printf("Registry %s (%s) is healthy", regConfig.Name, regConfig.URL)
-		c.setRegistryMetric(regConfig.Name, regConfig.URL, 1)
-	} else {
-		errorReason := classifyHTTPStatus(resp.StatusCode)
-		log.Printf("Registry %s (%s) returned unexpected status: %d [reason: %s]", regConfig.Name, regConfig.URL, resp.StatusCode, errorReason)
-		c.setRegistryMetric(regConfig.Name, regConfig.URL, 0)
-		metrics.HealthCheckErrors.WithLabelValues("registry", fmt.Sprintf("status_%d", resp.StatusCode)).Inc()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return result.down(fmt.Sprintf("status_%d", resp.StatusCode), classifyHTTPStatus(resp.StatusCode),
+			fmt.Sprintf("Registry %s (%s) returned unexpected status: %d", regConfig.Name, regConfig.URL, resp.StatusCode))
+	}
+
+	return result.up(fmt.Sprintf("Registry %s (%s) is healthy", regConfig.Name, regConfig.URL))
+}
+
+// registryBaseURL returns the URL the registry API is served under
+// oci://host/repository URLs name a repository, the API of which is served at the root of the host
+func registryBaseURL(regConfig config.RegistryConfig) string {
+	url := regConfig.URL
+	if rest, ok := strings.CutPrefix(url, "oci://"); ok {
+		url, _, _ = strings.Cut(rest, "/")
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		if regConfig.Insecure {
+			url = "http://" + url
+		} else {
+			url = "https://" + url
+		}
 	}
+	return url
 }
 
 // classifyRegistryError classifies registry connection errors for better troubleshooting
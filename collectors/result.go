@@ -0,0 +1,94 @@
+package collectors
+
+import (
+	"fmt"
+	"time"
+)
+
+// Status is the outcome of a check run against a target
+type Status string
+
+const (
+	// StatusUp means the target is healthy
+	StatusUp Status = "up"
+	// StatusDown means the target failed the check
+	StatusDown Status = "down"
+	// StatusUnknown means the target could not be judged because a dependency of the check is not up
+	StatusUnknown Status = "unknown"
+)
+
+// CheckResult is the typed outcome of one check run against one target
+type CheckResult struct {
+	// Check is the name of the scheduled check that produced the result
+	Check string `json:"check"`
+	// Source is the source of the check's target, one of the config.Source* constants
+	Source string `json:"source"`
+	// Kind is the check type, used as the "collector" label of the health_check_* metrics
+	Kind string `json:"kind"`
+	// Target identifies what was checked, e.g. a database instance or a storage class
+	Target string `json:"target"`
+	// Labels are the label values of the check's availability metric for this target
+	Labels map[string]string `json:"labels,omitempty"`
+
+	Status Status `json:"status"`
+	// Reason is the classified failure reason, e.g. "连接超时"
+	Reason string `json:"reason,omitempty"`
+	// ErrorType is the failing step, used as the "error_type" label of health_check_errors_total
+	ErrorType string `json:"error_type,omitempty"`
+	Message   string `json:"message"`
+	// Values are extra numeric measurements, exported through the check's value metric
+	Values map[string]float64 `json:"values,omitempty"`
+	// Info are extra text facts, e.g. a schema version, exported through the check's info metric
+	Info map[string]string `json:"info,omitempty"`
+	// AllTargets marks a failure to enumerate the targets of the check, e.g. the storage classes.
+	// The sink reports it against every target of the previous run rather than deleting their series
+	AllTargets bool `json:"-"`
+
+	Latency   time.Duration `json:"latency"`
+	StartTime time.Time     `json:"start_time"`
+	EndTime   time.Time     `json:"end_time"`
+	Attempts  int           `json:"attempts"`
+}
+
+// newResult starts a result for a target, recording the start time
+func newResult(kind, target string, labels map[string]string) CheckResult {
+	return CheckResult{
+		Kind:      kind,
+		Target:    target,
+		Labels:    labels,
+		StartTime: time.Now(),
+		Attempts:  1,
+	}
+}
+
+// up completes the result as healthy
+func (r CheckResult) up(message string) CheckResult {
+	r.Status = StatusUp
+	r.Message = message
+	return r.finish()
+}
+
+// down completes the result as failed at errorType with the classified reason
+func (r CheckResult) down(errorType, reason, message string) CheckResult {
+	r.Status = StatusDown
+	r.ErrorType = errorType
+	r.Reason = reason
+	r.Message = message
+	return r.finish()
+}
+
+// blocked marks the result as unknown because the dependency check is not up
+func (r CheckResult) blocked(dependency string) CheckResult {
+	r.Status = StatusUnknown
+	r.ErrorType = ""
+	r.Reason = "依赖检查异常"
+	r.Message = fmt.Sprintf("Check %s target %s is blocked: dependency %s is not up", r.Check, r.Target, dependency)
+	return r
+}
+
+// finish records the end time and latency of the result
+func (r CheckResult) finish() CheckResult {
+	r.EndTime = time.Now()
+	r.Latency = r.EndTime.Sub(r.StartTime)
+	return r
+}
@@ -0,0 +1,623 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rainbond/health-console/config"
+	"github.com/rainbond/health-console/metrics"
+)
+
+// CheckState describes the scheduling state of a single check
+type CheckState struct {
+	Collector    string        `json:"collector"`
+	Name         string        `json:"name"`
+	Source       string        `json:"source"`
+	Interval     time.Duration `json:"interval"`
+	Timeout      time.Duration `json:"timeout"`
+	Jitter       time.Duration `json:"jitter"`
+	Runs         uint64        `json:"runs"`
+	Skipped      uint64        `json:"skipped"`
+	Overruns     uint64        `json:"overruns"`
+	Running      bool          `json:"running"`
+	LastStart    time.Time     `json:"last_start"`
+	LastDuration time.Duration `json:"last_duration"`
+	// BlockedBy is the dependency that is not up, if the check is currently blocked
+	BlockedBy string `json:"blocked_by,omitempty"`
+}
+
+// scheduledCheck is a check together with its runtime state
+type scheduledCheck struct {
+	collector string
+	check     Check
+
+	// ctx is cancelled when the check is removed from the scheduler or the scheduler stops
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	state CheckState
+	// removed is set once the check has been taken out of the scheduler by a reload
+	removed bool
+	// forget deletes the series of the check once its last run has finished
+	forget bool
+}
+
+// Scheduler owns the timing of every check contributed by the registered collectors
+type Scheduler struct {
+	mu         sync.Mutex
+	collectors []Collector
+	checks     map[string]*scheduledCheck
+	sink       *Sink
+
+	// In scrape-driven mode checks run on collection, waiting at most scrapeTimeout for results
+	mode          string
+	scrapeTimeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// wg tracks check loops and in-flight runs
+	wg sync.WaitGroup
+
+	// reloadMu serializes reloads, which release mu while starting a collector
+	reloadMu sync.Mutex
+}
+
+// NewScheduler creates an empty scheduler publishing check results to sink
+// In config.ModeScrape checks only run when the scheduler is collected by Prometheus
+func NewScheduler(sink *Sink, cfg *config.Config) *Scheduler {
+	return &Scheduler{
+		checks:        make(map[string]*scheduledCheck),
+		sink:          sink,
+		mode:          cfg.CollectMode,
+		scrapeTimeout: cfg.ScrapeTimeout,
+	}
+}
+
+// Add registers a collector and its checks with the scheduler
+func (s *Scheduler) Add(c Collector) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.validate(c, ""); err != nil {
+		return err
+	}
+
+	for _, check := range c.Checks() {
+		s.checks[check.Name] = newScheduledCheck(c.Name(), check)
+	}
+	s.collectors = append(s.collectors, c)
+	return nil
+}
+
+// Reload replaces the running collector called name with c, or removes it if c is nil, and
+// adds c if no collector of that name is running. The checks of the old collector are retired
+// first, so their in-flight runs are cancelled before c starts, then c is started without holding
+// the scheduler lock and the old collector is stopped. If c fails to start, the checks of the old
+// collector are scheduled again. Checks that disappear have their series deleted once their last
+// run finishes
+func (s *Scheduler) Reload(name string, c Collector) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	s.mu.Lock()
+	if err := s.running(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	var newChecks []Check
+	if c != nil {
+		if err := s.validate(c, name); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		newChecks = c.Checks()
+	}
+
+	// Reject dependency cycles before touching the running checks
+	checks := make(map[string]*scheduledCheck, len(s.checks))
+	for checkName, sc := range s.checks {
+		if sc.collector != name {
+			checks[checkName] = sc
+		}
+	}
+	for _, check := range newChecks {
+		checks[check.Name] = newScheduledCheck(c.Name(), check)
+	}
+	if err := checkDependencies(checks); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	// Retire the checks of the old collector; their series are kept until c has started
+	var retired []*scheduledCheck
+	for checkName, sc := range s.checks {
+		if sc.collector == name {
+			s.retire(sc, false)
+			retired = append(retired, sc)
+			delete(s.checks, checkName)
+		}
+	}
+	s.mu.Unlock()
+
+	if c != nil {
+		if err := c.Start(s.ctx); err != nil {
+			s.restore(retired)
+			return fmt.Errorf("failed to start %s collector: %w", c.Name(), err)
+		}
+	}
+
+	s.mu.Lock()
+	if err := s.running(); err != nil {
+		s.mu.Unlock()
+		if c != nil {
+			if stopErr := c.Stop(context.WithoutCancel(s.ctx)); stopErr != nil {
+				log.Printf("Error stopping %s collector: %v", name, stopErr)
+			}
+		}
+		return err
+	}
+
+	var old Collector
+	collectors := make([]Collector, 0, len(s.collectors)+1)
+	for _, existing := range s.collectors {
+		if existing.Name() == name {
+			old = existing
+			continue
+		}
+		collectors = append(collectors, existing)
+	}
+	if c != nil {
+		collectors = append(collectors, c)
+	}
+	s.collectors = collectors
+
+	for _, check := range newChecks {
+		sc := checks[check.Name]
+		s.checks[check.Name] = sc
+		s.schedule(sc)
+	}
+	// Checks kept under the same name keep their series
+	for _, sc := range retired {
+		if _, kept := s.checks[sc.check.Name]; !kept {
+			s.forget(sc)
+		}
+	}
+	s.mu.Unlock()
+
+	if old != nil {
+		if err := old.Stop(s.ctx); err != nil {
+			log.Printf("Error stopping replaced %s collector: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// running returns an error unless the scheduler is started and not stopping
+// The caller must hold s.mu
+func (s *Scheduler) running() error {
+	if s.ctx == nil {
+		return fmt.Errorf("scheduler is not started")
+	}
+	if s.ctx.Err() != nil {
+		return fmt.Errorf("scheduler is stopping")
+	}
+	return nil
+}
+
+// restore schedules the retired checks of a collector again, after its replacement failed to start
+func (s *Scheduler) restore(retired []*scheduledCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running() != nil {
+		return
+	}
+	for _, sc := range retired {
+		restored := newScheduledCheck(sc.collector, sc.check)
+		s.checks[sc.check.Name] = restored
+		s.schedule(restored)
+	}
+}
+
+// validate checks the checks of a collector against the registered ones, ignoring the checks of
+// the collector called replacing
+func (s *Scheduler) validate(c Collector, replacing string) error {
+	for _, check := range c.Checks() {
+		if sc, exists := s.checks[check.Name]; exists && sc.collector != replacing {
+			return fmt.Errorf("duplicate check name %q from collector %s", check.Name, c.Name())
+		}
+		if check.Interval <= 0 {
+			return fmt.Errorf("check %q has non-positive interval %s", check.Name, check.Interval)
+		}
+	}
+	return nil
+}
+
+// newScheduledCheck creates the runtime state of a check of the named collector
+func newScheduledCheck(collector string, check Check) *scheduledCheck {
+	return &scheduledCheck{
+		collector: collector,
+		check:     check,
+		state: CheckState{
+			Collector: collector,
+			Name:      check.Name,
+			Source:    check.Source,
+			Interval:  check.Interval,
+			Timeout:   check.Timeout,
+			Jitter:    check.Jitter,
+		},
+	}
+}
+
+// schedule derives the context of a check and, in background mode, starts its loop
+// The caller must hold s.mu
+func (s *Scheduler) schedule(sc *scheduledCheck) {
+	sc.ctx, sc.cancel = context.WithCancel(s.ctx)
+	if s.mode == config.ModeScrape {
+		return
+	}
+
+	s.wg.Add(1)
+	go s.loop(sc)
+}
+
+// retire stops a removed check and cancels its in-flight run; the caller must hold s.mu
+// If forget is set its series are deleted, right away or once the in-flight run has finished
+func (s *Scheduler) retire(sc *scheduledCheck, forget bool) {
+	sc.mu.Lock()
+	sc.removed = true
+	sc.forget = forget
+	running := sc.state.Running
+	sc.mu.Unlock()
+
+	sc.cancel()
+	if forget && !running {
+		s.sink.Forget(sc.check)
+	}
+}
+
+// forget deletes the series of a retired check, right away or once its in-flight run has finished
+func (s *Scheduler) forget(sc *scheduledCheck) {
+	sc.mu.Lock()
+	sc.forget = true
+	running := sc.state.Running
+	sc.mu.Unlock()
+
+	if !running {
+		s.sink.Forget(sc.check)
+	}
+}
+
+// Start starts every collector and begins scheduling their checks
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := checkDependencies(s.checks); err != nil {
+		return err
+	}
+
+	s.ctx, s.cancel = context.WithCancel(ctx)
+
+	for _, c := range s.collectors {
+		if err := c.Start(s.ctx); err != nil {
+			s.cancel()
+			return fmt.Errorf("failed to start %s collector: %w", c.Name(), err)
+		}
+	}
+
+	for _, sc := range s.checks {
+		s.schedule(sc)
+	}
+	if s.mode == config.ModeScrape {
+		log.Println("Scrape-driven mode: checks run when Prometheus scrapes /metrics")
+	}
+	return nil
+}
+
+// Stop stops scheduling checks, cancels in-flight runs and waits for them to finish
+// (including their cleanup) until ctx expires, then stops every collector
+func (s *Scheduler) Stop(ctx context.Context) error {
+	// Cancelling under the lock orders it with reloads, which schedule no checks once it is done
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	collectors := s.collectors
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out waiting for in-flight checks: %w", ctx.Err())
+	}
+
+	for _, c := range collectors {
+		if stopErr := c.Stop(ctx); stopErr != nil {
+			log.Printf("Error stopping %s collector: %v", c.Name(), stopErr)
+		}
+	}
+	return err
+}
+
+// States returns the state of every scheduled check, sorted by name
+func (s *Scheduler) States() []CheckState {
+	checks := s.snapshot()
+	states := make([]CheckState, 0, len(checks))
+	for _, sc := range checks {
+		sc.mu.Lock()
+		states = append(states, sc.state)
+		sc.mu.Unlock()
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// snapshot returns the currently scheduled checks
+func (s *Scheduler) snapshot() []*scheduledCheck {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checks := make([]*scheduledCheck, 0, len(s.checks))
+	for _, sc := range s.checks {
+		checks = append(checks, sc)
+	}
+	return checks
+}
+
+// loop runs a check immediately and then once per interval, each run delayed by a random jitter
+func (s *Scheduler) loop(sc *scheduledCheck) {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(jitter(sc.check.Jitter))
+	defer timer.Stop()
+
+	next := time.Now()
+	for {
+		select {
+		case <-timer.C:
+			// Single-flight: never start a run while the previous one is still going
+			if sc.begin() {
+				s.wg.Add(1)
+				go s.run(sc)
+			} else {
+				log.Printf("Skipping check %s: previous run still in progress", sc.check.Name)
+				metrics.HealthCheckSkipped.WithLabelValues(sc.check.Name).Inc()
+			}
+
+			// Keep the interval anchored to the schedule rather than to the jittered start
+			next = next.Add(sc.check.Interval)
+			timer.Reset(time.Until(next) + jitter(sc.check.Jitter))
+		case <-sc.ctx.Done():
+			return
+		}
+	}
+}
+
+// jitter returns a random duration in [0, max)
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max)
+}
+
+// begin marks the check as running, or reports false if a run is already in flight
+func (sc *scheduledCheck) begin() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.state.Running {
+		sc.state.Skipped++
+		return false
+	}
+	sc.state.Running = true
+	sc.state.Runs++
+	sc.state.LastStart = time.Now()
+	return true
+}
+
+// end marks the check as finished and records overruns of its interval
+// It reports whether the series of the check are to be deleted because it was removed meanwhile
+func (sc *scheduledCheck) end() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.state.Running = false
+	sc.state.LastDuration = time.Since(sc.state.LastStart)
+	if sc.state.LastDuration > sc.check.Interval {
+		sc.state.Overruns++
+		log.Printf("Check %s took %s, longer than its interval %s", sc.check.Name, sc.state.LastDuration, sc.check.Interval)
+		metrics.HealthCheckOverruns.WithLabelValues(sc.check.Name).Inc()
+	}
+	return sc.removed && sc.forget
+}
+
+// isRemoved reports whether the check has been removed by a reload
+func (sc *scheduledCheck) isRemoved() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.removed
+}
+
+// isDown reports whether a result is down
+func isDown(r CheckResult) bool {
+	return r.Status == StatusDown
+}
+
+// blockedBy returns the dependency blocking the check, empty if it is not blocked
+func (sc *scheduledCheck) blockedBy() string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.state.BlockedBy
+}
+
+// setBlockedBy records the dependency blocking the check, empty if it is not blocked
+func (sc *scheduledCheck) setBlockedBy(dependency string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.state.BlockedBy = dependency
+}
+
+// run executes a check once; the caller must have marked it as running with begin
+func (s *Scheduler) run(sc *scheduledCheck) {
+	defer s.wg.Done()
+	defer func() {
+		if sc.end() {
+			s.sink.Forget(sc.check)
+		}
+	}()
+
+	ctx := sc.ctx
+	if sc.check.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sc.check.Timeout)
+		defer cancel()
+	}
+
+	// Running a check whose dependency is not up only adds to the alert storm, its previous
+	// targets are reported as unknown instead
+	if dependency := s.failedDependency(sc.check); dependency != "" {
+		s.block(sc, s.sink.Latest(sc.check.Name), dependency)
+		return
+	}
+
+	results := sc.check.Run(ctx)
+	for i := range results {
+		results[i].Check = sc.check.Name
+		results[i].Source = sc.check.Source
+	}
+
+	// A check removed by a reload while running must not publish over its replacement
+	if sc.isRemoved() {
+		return
+	}
+	s.record(sc, results)
+}
+
+// record publishes the results of a check and re-evaluates the checks depending on it
+func (s *Scheduler) record(sc *scheduledCheck, results []CheckResult) {
+	// The dependency may have failed while the check was running
+	if dependency := s.failedDependency(sc.check); dependency != "" && slices.ContainsFunc(results, isDown) {
+		s.block(sc, results, dependency)
+		return
+	}
+
+	sc.setBlockedBy("")
+	s.sink.Record(sc.check, results)
+	s.propagate(sc.check.Name)
+}
+
+// block reports every target of a check as unknown because dependency is not up
+func (s *Scheduler) block(sc *scheduledCheck, results []CheckResult, dependency string) {
+	for i := range results {
+		results[i] = results[i].blocked(dependency)
+	}
+
+	sc.setBlockedBy(dependency)
+	s.sink.Block(sc.check, results, dependency)
+	s.propagate(sc.check.Name)
+}
+
+// propagate blocks the checks depending on name that are currently reported down, so a failing
+// dependency replaces the alerts of its dependents even before they run again
+func (s *Scheduler) propagate(name string) {
+	if s.sink.Status(name) == StatusUp {
+		return
+	}
+
+	for _, dependent := range s.snapshot() {
+		if slices.Contains(dependent.check.DependsOn, name) && s.sink.Status(dependent.check.Name) == StatusDown {
+			s.block(dependent, s.sink.Latest(dependent.check.Name), name)
+		}
+	}
+}
+
+// failedDependency returns the first dependency of a check that is not up, if any
+// Dependencies that have not reported yet are assumed to be up unless they are blocked themselves
+func (s *Scheduler) failedDependency(check Check) string {
+	for _, dependency := range check.DependsOn {
+		switch s.sink.Status(dependency) {
+		case StatusDown, StatusUnknown:
+			return dependency
+		}
+
+		s.mu.Lock()
+		sc, ok := s.checks[dependency]
+		s.mu.Unlock()
+		if ok && sc.blockedBy() != "" {
+			return dependency
+		}
+	}
+	return ""
+}
+
+// checkDependencies warns about dependencies on checks that are not registered and rejects
+// dependency cycles, which would block their checks forever
+func checkDependencies(checks map[string]*scheduledCheck) error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	marks := make(map[string]int, len(checks))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch marks[name] {
+		case visiting:
+			// The path may lead into the cycle from a check outside of it
+			cycle := append(path[slices.Index(path, name):], name)
+			return fmt.Errorf("dependency cycle between checks: %s", strings.Join(cycle, " -> "))
+		case visited:
+			return nil
+		}
+
+		marks[name] = visiting
+		for _, dependency := range checks[name].check.DependsOn {
+			if _, ok := checks[dependency]; !ok {
+				continue
+			}
+			if err := visit(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+		marks[name] = visited
+		return nil
+	}
+
+	for name, sc := range checks {
+		for _, dependency := range sc.check.DependsOn {
+			if _, ok := checks[dependency]; !ok {
+				log.Printf("Check %s depends on %s, which is not registered; ignoring the dependency", name, dependency)
+			}
+		}
+	}
+
+	for name := range checks {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/rainbond/health-console/config"
+)
+
+// fakeCollector is a collector of checks that report their target up
+type fakeCollector struct {
+	name    string
+	checks  []string
+	start   func(ctx context.Context) error
+	stopped atomic.Bool
+	runs    atomic.Int64
+}
+
+func (c *fakeCollector) Name() string { return c.name }
+
+func (c *fakeCollector) Checks() []Check {
+	checks := make([]Check, 0, len(c.checks))
+	for _, name := range c.checks {
+		checks = append(checks, newCheck(name, config.CheckConfig{Interval: time.Hour}, nil, single(func(ctx context.Context) CheckResult {
+			c.runs.Add(1)
+			return newResult("fake", name, nil).up("ok")
+		})))
+	}
+	return checks
+}
+
+func (c *fakeCollector) Start(ctx context.Context) error {
+	if c.start != nil {
+		return c.start(ctx)
+	}
+	return nil
+}
+
+func (c *fakeCollector) Stop(ctx context.Context) error {
+	c.stopped.Store(true)
+	return nil
+}
+
+// newTestScheduler starts a scheduler with the collectors, stopped when the test ends
+func newTestScheduler(t *testing.T, collectors ...Collector) *Scheduler {
+	t.Helper()
+	s := NewScheduler(NewSink(), &config.Config{CollectMode: config.ModeBackground})
+	for _, c := range collectors {
+		if err := s.Add(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Stop(context.Background()) })
+	return s
+}
+
+// checkNames returns the names of the scheduled checks
+func checkNames(s *Scheduler) []string {
+	var names []string
+	for _, state := range s.States() {
+		names = append(names, state.Name)
+	}
+	return names
+}
+
+func TestReloadStartsCollectorWithoutLock(t *testing.T) {
+	s := newTestScheduler(t, &fakeCollector{name: "fake", checks: []string{"fake/a"}})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	next := &fakeCollector{name: "fake", checks: []string{"fake/b"}, start: func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}}
+	done := make(chan error)
+	go func() { done <- s.Reload("fake", next) }()
+
+	<-started
+	// A slow start must not block the API and the dependency lookups
+	states := make(chan []string)
+	go func() { states <- checkNames(s) }()
+	select {
+	case names := <-states:
+		if len(names) != 0 {
+			t.Errorf("checks while starting = %v, want the old checks retired", names)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("States blocked while the collector was starting")
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if names := checkNames(s); len(names) != 1 || names[0] != "fake/b" {
+		t.Errorf("checks after reload = %v, want [fake/b]", names)
+	}
+}
+
+func TestReloadRetiresOldChecksBeforeStart(t *testing.T) {
+	running := make(chan struct{})
+	cancelled := make(chan struct{})
+	s := newTestScheduler(t, &fakeCollector{name: "fake"})
+	// A check whose run only ends when cancelled, like a storage class check waiting for its PVC
+	blocking := &fakeCollector{name: "fake"}
+	blockingCheck := newCheck("fake/pvc", config.CheckConfig{Interval: time.Hour}, nil, single(func(ctx context.Context) CheckResult {
+		close(running)
+		<-ctx.Done()
+		close(cancelled)
+		return newResult("fake", "pvc", nil).down("cancelled", "", "cancelled")
+	}))
+	if err := s.Reload("fake", &staticCollector{fakeCollector: blocking, checks: []Check{blockingCheck}}); err != nil {
+		t.Fatal(err)
+	}
+	<-running
+
+	// The cancellation is asynchronous, give the run a moment to see it
+	next := &fakeCollector{name: "fake", start: func(ctx context.Context) error {
+		select {
+		case <-cancelled:
+			return nil
+		case <-time.After(time.Second):
+			return errors.New("old run was not cancelled before start")
+		}
+	}}
+	if err := s.Reload("fake", next); err != nil {
+		t.Fatal(err)
+	}
+	if !blocking.stopped.Load() {
+		t.Error("replaced collector was not stopped")
+	}
+	if got := s.sink.Status("fake/pvc"); got != "" {
+		t.Errorf("cancelled run of a retired check published status %q", got)
+	}
+}
+
+func TestReloadRestoresChecksWhenStartFails(t *testing.T) {
+	old := &fakeCollector{name: "fake", checks: []string{"fake/a"}}
+	s := newTestScheduler(t, old)
+
+	next := &fakeCollector{name: "fake", checks: []string{"fake/b"}, start: func(ctx context.Context) error {
+		return errors.New("boom")
+	}}
+	if err := s.Reload("fake", next); err == nil {
+		t.Fatal("Reload succeeded with a collector failing to start")
+	}
+	if names := checkNames(s); len(names) != 1 || names[0] != "fake/a" {
+		t.Errorf("checks after failed reload = %v, want [fake/a]", names)
+	}
+	if old.stopped.Load() {
+		t.Error("old collector was stopped although its replacement failed to start")
+	}
+}
+
+func TestReloadAfterStop(t *testing.T) {
+	s := newTestScheduler(t, &fakeCollector{name: "fake", checks: []string{"fake/a"}})
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	next := &fakeCollector{name: "fake", checks: []string{"fake/b"}}
+	if err := s.Reload("fake", next); err == nil {
+		t.Fatal("Reload succeeded on a stopped scheduler")
+	}
+	if next.runs.Load() != 0 {
+		t.Error("checks of a stopped scheduler ran")
+	}
+}
+
+func TestReloadStoppingDuringStart(t *testing.T) {
+	s := newTestScheduler(t, &fakeCollector{name: "fake", checks: []string{"fake/a"}})
+
+	stopped := make(chan error)
+	next := &fakeCollector{name: "fake", checks: []string{"fake/b"}}
+	next.start = func(ctx context.Context) error {
+		go func() { stopped <- s.Stop(context.Background()) }()
+		<-ctx.Done()
+		return nil
+	}
+	if err := s.Reload("fake", next); err == nil {
+		t.Fatal("Reload succeeded while the scheduler was stopping")
+	}
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	if !next.stopped.Load() {
+		t.Error("collector started during shutdown was not stopped")
+	}
+}
+
+// staticCollector is a fakeCollector with fixed checks
+type staticCollector struct {
+	*fakeCollector
+	checks []Check
+}
+
+func (c *staticCollector) Checks() []Check { return c.checks }
+
+func TestCheckDependencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		deps    map[string][]string
+		wantErr []string
+	}{
+		{name: "no dependencies", deps: map[string][]string{"a": nil, "b": nil}},
+		{name: "chain", deps: map[string][]string{"a": {"b"}, "b": {"c"}, "c": nil}},
+		{name: "diamond", deps: map[string][]string{"a": {"b", "c"}, "b": {"d"}, "c": {"d"}, "d": nil}},
+		{name: "unregistered dependency is ignored", deps: map[string][]string{"a": {"missing"}}},
+		{name: "self dependency", deps: map[string][]string{"a": {"a"}}, wantErr: []string{"a -> a"}},
+		{name: "two checks", deps: map[string][]string{"a": {"b"}, "b": {"a"}}, wantErr: []string{"dependency cycle", "a", "b"}},
+		{name: "cycle behind a chain", deps: map[string][]string{"x": {"a"}, "a": {"b"}, "b": {"c"}, "c": {"a"}}, wantErr: []string{"dependency cycle", "a", "b", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks := make(map[string]*scheduledCheck, len(tt.deps))
+			for name, deps := range tt.deps {
+				check := newCheck(name, config.CheckConfig{Interval: time.Hour}, nil, nil)
+				check.DependsOn = deps
+				checks[name] = &scheduledCheck{check: check}
+			}
+
+			err := checkDependencies(checks)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("checkDependencies() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("checkDependencies() = nil, want a cycle error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("checkDependencies() = %q, want containing %q", err, want)
+				}
+			}
+			// Checks leading into the cycle are not part of it
+			if strings.Contains(err.Error(), "x") {
+				t.Errorf("checkDependencies() = %q, want only the checks of the cycle", err)
+			}
+		})
+	}
+}
+
+func TestReloadRefusesDependencyCycle(t *testing.T) {
+	old := &fakeCollector{name: "fake", checks: []string{"fake/a"}}
+	s := newTestScheduler(t, old, &fakeCollector{name: "other", checks: []string{"other/b"}})
+
+	a := newCheck("fake/a", config.CheckConfig{Interval: time.Hour}, nil, nil)
+	a.DependsOn = []string{"other/b", "fake/b"}
+	b := newCheck("fake/b", config.CheckConfig{Interval: time.Hour}, nil, nil)
+	b.DependsOn = []string{"fake/a"}
+	next := &staticCollector{fakeCollector: &fakeCollector{name: "fake"}, checks: []Check{a, b}}
+	if err := s.Reload("fake", next); err == nil || !strings.Contains(err.Error(), "dependency cycle") {
+		t.Fatalf("Reload() = %v, want a dependency cycle error", err)
+	}
+	if old.stopped.Load() {
+		t.Error("old collector was stopped by a refused reload")
+	}
+	if names := checkNames(s); len(names) != 2 {
+		t.Errorf("checks after refused reload = %v, want the old ones", names)
+	}
+}
+
+// storageCollector is a Kubernetes collector with only its storage class check, the other checks
+// need a real API server
+type storageCollector struct {
+	*KubernetesCollector
+	beforeStop func()
+}
+
+func (c *storageCollector) Checks() []Check {
+	return slices.DeleteFunc(c.KubernetesCollector.Checks(), func(check Check) bool {
+		return check.Name != "kubernetes/storage_class"
+	})
+}
+
+func (c *storageCollector) Stop(ctx context.Context) error {
+	if c.beforeStop != nil {
+		c.beforeStop()
+	}
+	return c.KubernetesCollector.Stop(ctx)
+}
+
+// newStorageCollector creates a collector testing the storage classes of clientset, whose test
+// PVCs never bind
+func newStorageCollector(clientset *fake.Clientset) *storageCollector {
+	return &storageCollector{KubernetesCollector: &KubernetesCollector{
+		clientset: clientset,
+		checks:    config.KubernetesConfig{StorageClass: config.CheckConfig{Interval: time.Hour, Timeout: time.Minute}},
+		profile:   config.ClusterProfile{ProbeNamespace: "rbd-system"},
+		pvcs:      make(map[string]bool),
+	}}
+}
+
+// testPVCs returns the names of the test PVCs in the cluster of clientset
+func testPVCs(t *testing.T, clientset *fake.Clientset) []string {
+	t.Helper()
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims("rbd-system").List(context.Background(), metav1.ListOptions{LabelSelector: testPVCSelector})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pvc := range pvcs.Items {
+		names = append(names, pvc.Name)
+	}
+	return names
+}
+
+// waitFor polls until condition holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestReloadKeepsTestPVCsOfNewCollector(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "local"}})
+	// The fake API server does not generate names
+	var created atomic.Int64
+	clientset.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pvc := action.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim)
+		if pvc.Name == "" {
+			pvc.Name = fmt.Sprintf("%s%d", pvc.GenerateName, created.Add(1))
+		}
+		return false, nil, nil
+	})
+
+	old := newStorageCollector(clientset)
+	s := newTestScheduler(t, old)
+	waitFor(t, "the test PVC of the old collector", func() bool { return len(testPVCs(t, clientset)) == 1 })
+	oldPVC := testPVCs(t, clientset)[0]
+
+	// The old collector is stopped while the storage check of its replacement binds its test PVC
+	next := newStorageCollector(clientset)
+	old.beforeStop = func() {
+		waitFor(t, "the test PVC of the new collector", func() bool {
+			names := testPVCs(t, clientset)
+			return len(names) == 1 && names[0] != oldPVC
+		})
+	}
+	if err := s.Reload("kubernetes", next); err != nil {
+		t.Fatal(err)
+	}
+
+	next.mu.Lock()
+	inFlight := slices.Collect(maps.Keys(next.pvcs))
+	next.mu.Unlock()
+	if names := testPVCs(t, clientset); len(inFlight) != 1 || !slices.Equal(names, inFlight) {
+		t.Errorf("test PVCs after reload = %v, want the in-flight PVC %v of the new collector", names, inFlight)
+	}
+
+	// Shutting down still removes the test PVCs
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if names := testPVCs(t, clientset); len(names) != 0 {
+		t.Errorf("test PVCs after shutdown = %v, want none", names)
+	}
+}
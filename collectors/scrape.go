@@ -0,0 +1,91 @@
+package collectors
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rainbond/health-console/config"
+)
+
+// cacheAgeDesc describes the age of the cached result of a check at scrape time
+var cacheAgeDesc = prometheus.NewDesc(
+	"health_check_cache_age_seconds",
+	"Age of the cached result of a health check when scraped",
+	[]string{"check"},
+	nil,
+)
+
+// Describe implements prometheus.Collector
+func (s *Scheduler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheAgeDesc
+}
+
+// Collect implements prometheus.Collector
+// In scrape-driven mode it first runs every check whose cached result is older than the check
+// interval and waits for them, so the health metrics gathered right after are fresh
+func (s *Scheduler) Collect(ch chan<- prometheus.Metric) {
+	if s.mode == config.ModeScrape {
+		s.refresh()
+	}
+
+	now := time.Now()
+	for _, sc := range s.snapshot() {
+		sc.mu.Lock()
+		lastStart, runs := sc.state.LastStart, sc.state.Runs
+		sc.mu.Unlock()
+
+		if runs == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(cacheAgeDesc, prometheus.GaugeValue, now.Sub(lastStart).Seconds(), sc.check.Name)
+	}
+}
+
+// refresh runs every due check concurrently and waits for them to finish, at most scrapeTimeout
+// Checks still running afterwards complete in the background and are served on a later scrape.
+// The check interval acts as the minimum refresh interval, so frequent scrapes reuse cached results
+func (s *Scheduler) refresh() {
+	s.mu.Lock()
+	ctx := s.ctx
+	s.mu.Unlock()
+	if ctx == nil || ctx.Err() != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, sc := range s.snapshot() {
+		if !sc.due() || !sc.begin() {
+			continue
+		}
+
+		wg.Add(1)
+		s.wg.Add(1)
+		go func(sc *scheduledCheck) {
+			defer wg.Done()
+			s.run(sc)
+		}(sc)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(s.scrapeTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
+
+// due reports whether the check has never run or its last run is older than its interval
+func (sc *scheduledCheck) due() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.state.Runs == 0 || time.Since(sc.state.LastStart) >= sc.check.Interval
+}
@@ -0,0 +1,141 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/rainbond/health-console/config"
+	"github.com/rainbond/health-console/kube"
+)
+
+// secretTTL is how long a Kubernetes Secret is used before it is read again
+const secretTTL = time.Minute
+
+// secretEviction is how long a Secret no check reads is kept
+const secretEviction = 10 * secretTTL
+
+// SecretResolver reads the credentials of targets
+// Files are read again on every check run. Kubernetes Secrets are read at most once per secretTTL,
+// so the checks do not eat into the API rate limit of the cluster checks and discovery; rotated
+// credentials are used within secretTTL without a restart or reload
+type SecretResolver struct {
+	clientset func() (kubernetes.Interface, error) // Only used by Secret references
+
+	mu      sync.Mutex
+	secrets map[string]*cachedSecret // By namespace/name
+}
+
+// cachedSecret is the data of a Secret as last read
+type cachedSecret struct {
+	data map[string][]byte
+	read time.Time // When data was read
+	used time.Time // When a check last asked for the Secret
+}
+
+// NewSecretResolver creates a new secret resolver reading Kubernetes Secrets through clients
+func NewSecretResolver(clients *kube.Clients) *SecretResolver {
+	return &SecretResolver{
+		clientset: clients.Kubernetes,
+		secrets:   make(map[string]*cachedSecret),
+	}
+}
+
+// Resolve returns the current value of a credential
+func (r *SecretResolver) Resolve(ctx context.Context, secret config.Secret) (string, error) {
+	switch {
+	case secret.SecretRef != nil:
+		return r.readSecret(ctx, *secret.SecretRef)
+	case secret.File != "":
+		data, err := os.ReadFile(secret.File)
+		if err != nil {
+			return "", fmt.Errorf("failed to read credential file: %w", err)
+		}
+		// Files written by hand usually end with a newline that is not part of the credential
+		return strings.TrimRight(string(data), "\r\n"), nil
+	default:
+		return secret.Value, nil
+	}
+}
+
+// readSecret reads a key of a Kubernetes Secret, from the cache if it was read within secretTTL
+// If the Secret cannot be read, e.g. while the API server is unavailable, the data last read is
+// used, so the targets are not reported down for a failure of the cluster. A deleted Secret is not
+// served from the cache
+func (r *SecretResolver) readSecret(ctx context.Context, ref config.SecretKeyRef) (string, error) {
+	name := ref.Namespace + "/" + ref.Name
+	now := time.Now()
+
+	r.mu.Lock()
+	cached := r.secrets[name]
+	if cached != nil {
+		cached.used = now
+	}
+	r.mu.Unlock()
+
+	if cached == nil || now.Sub(cached.read) >= secretTTL {
+		data, err := r.getSecret(ctx, ref)
+		switch {
+		case err == nil:
+			cached = &cachedSecret{data: data, read: now, used: now}
+			r.store(name, cached)
+		case cached != nil && !apierrors.IsNotFound(err):
+			log.Printf("Warning: %v, using the secret read %s ago", err, now.Sub(cached.read).Round(time.Second))
+		default:
+			r.store(name, nil)
+			return "", err
+		}
+	}
+
+	value, ok := cached.data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %q", name, ref.Key)
+	}
+	return string(value), nil
+}
+
+// getSecret reads the data of a Kubernetes Secret from the API server
+func (r *SecretResolver) getSecret(ctx context.Context, ref config.SecretKeyRef) (map[string][]byte, error) {
+	clientset, err := r.clientset()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := clientset.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	return secret.Data, nil
+}
+
+// store caches the Secret called name, or removes it if cached is nil, and evicts the Secrets no
+// check asked for within secretEviction, e.g. those of removed targets
+func (r *SecretResolver) store(name string, cached *cachedSecret) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cached == nil {
+		delete(r.secrets, name)
+	} else {
+		r.secrets[name] = cached
+	}
+	for other, s := range r.secrets {
+		if time.Since(s.used) >= secretEviction {
+			delete(r.secrets, other)
+		}
+	}
+}
+
+// credentialsUnavailable marks a result down because a credential could not be read
+func credentialsUnavailable(result CheckResult, target string, err error) CheckResult {
+	return result.down("credentials_unavailable", "凭据读取失败",
+		fmt.Sprintf("Failed to read credentials for %s: %v", target, err))
+}
@@ -0,0 +1,81 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/rainbond/health-console/config"
+)
+
+func TestResolveCachesSecrets(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "rbd-system", Name: "rbd-db"},
+		Data:       map[string][]byte{"password": []byte("secret"), "user": []byte("root")},
+	})
+	// The reactor runs in the goroutine of Resolve
+	var gets int
+	var failure error // Returned by the API server, if set
+	clientset.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if failure != nil {
+			return true, nil, failure
+		}
+		return false, nil, nil
+	})
+	r := &SecretResolver{
+		clientset: func() (kubernetes.Interface, error) { return clientset, nil },
+		secrets:   make(map[string]*cachedSecret),
+	}
+	resolve := func(key string) (string, error) {
+		return r.Resolve(context.Background(), config.Secret{SecretRef: &config.SecretKeyRef{Namespace: "rbd-system", Name: "rbd-db", Key: key}})
+	}
+	expire := func() {
+		r.mu.Lock()
+		r.secrets["rbd-system/rbd-db"].read = time.Now().Add(-secretTTL)
+		r.mu.Unlock()
+	}
+
+	// Every key of a Secret is served by a single read
+	for _, key := range []string{"password", "user", "password"} {
+		if _, err := resolve(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := gets; n != 1 {
+		t.Errorf("Secret read %d times, want once", n)
+	}
+	if _, err := resolve("missing"); err == nil {
+		t.Error("Resolve() of a missing key succeeded")
+	}
+
+	// An unavailable API server does not fail the checks
+	expire()
+	failure = errors.New("connection refused")
+	if value, err := resolve("password"); err != nil || value != "secret" {
+		t.Errorf("Resolve() while the API server fails = %q, %v, want the last value", value, err)
+	}
+	if n := gets; n != 2 {
+		t.Errorf("Secret read %d times, want 2", n)
+	}
+
+	// A deleted Secret does
+	failure = apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "rbd-db")
+	expire()
+	if _, err := resolve("password"); err == nil {
+		t.Error("Resolve() of a deleted Secret succeeded")
+	}
+	if _, err := resolve("password"); err == nil {
+		t.Error("Resolve() served a deleted Secret from the cache")
+	}
+}
@@ -0,0 +1,265 @@
+package collectors
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rainbond/health-console/metrics"
+)
+
+// Sink turns check results into metrics, logs and API output, so they can never disagree
+// It owns the series it exports: series of targets that disappear from a check are deleted
+type Sink struct {
+	mu      sync.RWMutex
+	results map[string]map[string]CheckResult // check name -> target -> latest result
+}
+
+// NewSink creates an empty result sink
+func NewSink() *Sink {
+	return &Sink{
+		results: make(map[string]map[string]CheckResult),
+	}
+}
+
+// Record publishes the results of one run of a check
+// Targets reported by the previous run but missing from this one (e.g. a deleted StorageClass)
+// have their series deleted, unless the run failed to enumerate its targets
+func (s *Sink) Record(check Check, results []CheckResult) {
+	metrics.HealthCheckBlocked.DeletePartialMatch(prometheus.Labels{"check": check.Name})
+	results = s.expand(check.Name, results)
+
+	current := make(map[string]CheckResult, len(results))
+	for _, r := range results {
+		s.publish(check.Metric, r)
+		publishValues(check, r)
+		current[r.Target] = r
+	}
+
+	s.mu.Lock()
+	previous := s.results[check.Name]
+	s.results[check.Name] = current
+	s.mu.Unlock()
+
+	for target, old := range previous {
+		r, ok := current[target]
+		if !ok {
+			log.Printf("Check %s no longer reports target %s, deleting its series", check.Name, target)
+			s.unpublish(check.Metric, old)
+			deleteValues(check, old)
+			continue
+		}
+		// The same target may now be exported with different labels, e.g. a changed host
+		if !reflect.DeepEqual(r.Labels, old.Labels) {
+			deleteGauge(check.Metric, old.Labels)
+			deleteValues(check, old)
+		}
+	}
+}
+
+// expand reports a result marked AllTargets against each target of the previous run of the check,
+// so that a transient enumeration failure marks the known targets down rather than deleting them
+// It is kept as is if the check has no known targets yet
+func (s *Sink) expand(check string, results []CheckResult) []CheckResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var expanded []CheckResult
+	for _, r := range results {
+		if !r.AllTargets {
+			expanded = append(expanded, r)
+			continue
+		}
+		known := false
+		for _, old := range s.results[check] {
+			if old.AllTargets {
+				continue
+			}
+			t := r
+			t.Target = old.Target
+			t.Labels = old.Labels
+			t.AllTargets = false
+			expanded = append(expanded, t)
+			known = true
+		}
+		if !known {
+			expanded = append(expanded, r)
+		}
+	}
+	return expanded
+}
+
+// Block publishes the results of a check whose dependency is not up
+// The results are expected to be marked unknown; the check is exported as blocked by dependency
+// and its targets keep their last availability
+func (s *Sink) Block(check Check, results []CheckResult, dependency string) {
+	s.Record(check, results)
+	metrics.HealthCheckBlocked.WithLabelValues(check.Name, dependency).Set(1)
+}
+
+// Forget deletes every series owned by a check that is no longer scheduled
+func (s *Sink) Forget(check Check) {
+	s.mu.Lock()
+	previous := s.results[check.Name]
+	delete(s.results, check.Name)
+	s.mu.Unlock()
+
+	metrics.HealthCheckBlocked.DeletePartialMatch(prometheus.Labels{"check": check.Name})
+	for _, old := range previous {
+		s.unpublish(check.Metric, old)
+		deleteValues(check, old)
+	}
+}
+
+// Latest returns the latest results of a check, sorted by target
+func (s *Sink) Latest(check string) []CheckResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make([]CheckResult, 0, len(s.results[check]))
+	for _, r := range s.results[check] {
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Target < results[j].Target })
+	return results
+}
+
+// Status summarizes the latest results of a check: down if any target is down, unknown if any
+// target is blocked, up otherwise. It is empty if the check has not reported yet
+func (s *Sink) Status(check string) Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var status Status
+	for _, r := range s.results[check] {
+		switch {
+		case r.Status == StatusDown:
+			return StatusDown
+		case r.Status == StatusUnknown:
+			status = StatusUnknown
+		case status == "":
+			status = StatusUp
+		}
+	}
+	return status
+}
+
+// Results returns the latest result of every check and target, sorted by check and target
+func (s *Sink) Results() []CheckResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []CheckResult
+	for _, targets := range s.results {
+		for _, r := range targets {
+			results = append(results, r)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Check != results[j].Check {
+			return results[i].Check < results[j].Check
+		}
+		return results[i].Target < results[j].Target
+	})
+	return results
+}
+
+// publish writes a single result to the metrics and the log
+func (s *Sink) publish(up *prometheus.GaugeVec, r CheckResult) {
+	// A blocked target was not judged, its availability and reason series keep their last values
+	// so that no series vanishes. Alerts tell it apart from a failure by health_check_blocked
+	if r.Status == StatusUnknown {
+		log.Printf("%s [reason: %s]", r.Message, r.Reason)
+		return
+	}
+
+	metrics.HealthCheckDuration.WithLabelValues(r.Kind).Observe(r.Latency.Seconds())
+	metrics.HealthCheckLastSeen.WithLabelValues(r.Kind, r.Target).Set(float64(r.EndTime.Unix()))
+
+	switch r.Status {
+	case StatusUp:
+		log.Print(r.Message)
+		setGauge(up, r.Labels, 1)
+		metrics.HealthCheckFailureReason.DeletePartialMatch(prometheus.Labels{"collector": r.Kind, "target": r.Target})
+	case StatusDown:
+		log.Printf("%s [reason: %s]", r.Message, r.Reason)
+		setGauge(up, r.Labels, 0)
+		metrics.HealthCheckErrors.WithLabelValues(r.Kind, r.ErrorType).Inc()
+
+		// Only the current reason is exported for a target
+		metrics.HealthCheckFailureReason.DeletePartialMatch(prometheus.Labels{"collector": r.Kind, "target": r.Target})
+		metrics.HealthCheckFailureReason.WithLabelValues(r.Kind, r.Target, r.Reason).Set(1)
+	}
+}
+
+// unpublish deletes every series exported for a result's target
+func (s *Sink) unpublish(up *prometheus.GaugeVec, r CheckResult) {
+	deleteGauge(up, r.Labels)
+	metrics.HealthCheckLastSeen.DeleteLabelValues(r.Kind, r.Target)
+	metrics.HealthCheckFailureReason.DeletePartialMatch(prometheus.Labels{"collector": r.Kind, "target": r.Target})
+}
+
+// setGauge sets an availability gauge for the given labels, if the check has one
+func setGauge(gauge *prometheus.GaugeVec, labels map[string]string, value float64) {
+	if gauge == nil {
+		return
+	}
+	gauge.With(labels).Set(value)
+}
+
+// deleteGauge deletes an availability gauge series, if the check has one
+func deleteGauge(gauge *prometheus.GaugeVec, labels map[string]string) {
+	if gauge == nil {
+		return
+	}
+	gauge.Delete(labels)
+}
+
+// publishValues replaces the value series of a result's target with its current values
+// Values missing from the result, e.g. the replication lag of an instance that is no longer a
+// replica, have their series deleted
+func publishValues(check Check, r CheckResult) {
+	deleteValues(check, r)
+	for key, value := range r.Values {
+		if gauge, ok := check.ValueGauges[key]; ok {
+			gauge.With(r.Labels).Set(value)
+			continue
+		}
+		if check.ValueMetric == nil {
+			continue
+		}
+		labels := prometheus.Labels{"key": key}
+		for name, v := range r.Labels {
+			labels[name] = v
+		}
+		check.ValueMetric.With(labels).Set(value)
+	}
+	if check.InfoMetric != nil && len(r.Info) > 0 {
+		labels := prometheus.Labels{}
+		for name, v := range r.Labels {
+			labels[name] = v
+		}
+		for name, v := range r.Info {
+			labels[name] = v
+		}
+		check.InfoMetric.With(labels).Set(1)
+	}
+}
+
+// deleteValues deletes every value series of a result's target
+func deleteValues(check Check, r CheckResult) {
+	for _, gauge := range check.ValueGauges {
+		gauge.Delete(r.Labels)
+	}
+	if check.ValueMetric != nil {
+		check.ValueMetric.DeletePartialMatch(r.Labels)
+	}
+	// The info labels change with the info, e.g. a new schema version replaces the series
+	if check.InfoMetric != nil {
+		check.InfoMetric.DeletePartialMatch(r.Labels)
+	}
+}
@@ -0,0 +1,142 @@
+package collectors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rainbond/health-console/config"
+)
+
+func TestRecordKeepsTargetsWhenEnumerationFails(t *testing.T) {
+	sink := NewSink()
+	check := newCheck("test/storage", config.CheckConfig{Interval: time.Hour}, nil, nil)
+
+	sink.Record(check, []CheckResult{
+		newStorageClassResult("local").up("ok"),
+		newStorageClassResult("nfs").up("ok"),
+	})
+
+	failed := newStorageClassResult("default")
+	failed.AllTargets = true
+	sink.Record(check, []CheckResult{failed.down("list_failed", "连接超时", "Failed to list storage classes")})
+
+	latest := sink.Latest(check.Name)
+	if len(latest) != 2 || latest[0].Target != "local" || latest[1].Target != "nfs" {
+		t.Fatalf("targets after a list failure = %+v, want local and nfs", latest)
+	}
+	for _, r := range latest {
+		if r.Status != StatusDown || r.ErrorType != "list_failed" || r.Labels["storage_class"] != r.Target {
+			t.Errorf("result of %s = %+v, want down with list_failed and its own labels", r.Target, r)
+		}
+	}
+
+	// A successful run that no longer lists nfs deletes it
+	sink.Record(check, []CheckResult{newStorageClassResult("local").up("ok")})
+	if latest := sink.Latest(check.Name); len(latest) != 1 || latest[0].Target != "local" {
+		t.Errorf("targets after nfs was deleted = %+v, want local", latest)
+	}
+}
+
+func TestRecordEnumerationFailureWithoutKnownTargets(t *testing.T) {
+	sink := NewSink()
+	check := newCheck("test/storage", config.CheckConfig{Interval: time.Hour}, nil, nil)
+
+	for range 2 {
+		failed := newStorageClassResult("default")
+		failed.AllTargets = true
+		sink.Record(check, []CheckResult{failed.down("list_failed", "连接超时", "Failed to list storage classes")})
+
+		latest := sink.Latest(check.Name)
+		if len(latest) != 1 || latest[0].Target != "default" || latest[0].Status != StatusDown {
+			t.Fatalf("results = %+v, want the failure against default", latest)
+		}
+	}
+}
+
+func TestRecordReplacesInfoSeries(t *testing.T) {
+	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_schema_info"}, []string{"instance", "role", "version"})
+	sink := NewSink()
+	check := newCheck("test/db", config.CheckConfig{Interval: time.Hour}, nil, nil)
+	check.InfoMetric = info
+
+	result := func(version string) CheckResult {
+		r := newResult("database", "console", map[string]string{"instance": "console"})
+		r.Info = map[string]string{"role": "console", "version": version}
+		return r.up("ok")
+	}
+
+	sink.Record(check, []CheckResult{result("1")})
+	sink.Record(check, []CheckResult{result("2")})
+	if n := seriesCount(info); n != 1 {
+		t.Errorf("info series after a version change = %d, want 1", n)
+	}
+	if !info.Delete(prometheus.Labels{"instance": "console", "role": "console", "version": "2"}) {
+		t.Error("info series of the latest version is missing")
+	}
+
+	sink.Record(check, []CheckResult{result("2")})
+	sink.Forget(check)
+	if n := seriesCount(info); n != 0 {
+		t.Errorf("info series after the check was forgotten = %d, want 0", n)
+	}
+}
+
+// seriesCount counts the series of a collector
+func seriesCount(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	n := 0
+	for range ch {
+		n++
+	}
+	return n
+}
+
+func TestBlockKeepsAvailabilitySeries(t *testing.T) {
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_up"}, []string{"instance"})
+	sink := NewSink()
+	check := newCheck("test/registry", config.CheckConfig{Interval: time.Hour}, up, nil)
+	result := func() CheckResult {
+		return newResult("registry", "hub", map[string]string{"instance": "hub"})
+	}
+
+	// A blocked target keeps its last value, so that alerts on absent series do not fire
+	for _, last := range []CheckResult{result().up("ok"), result().down("unreachable", "连接超时", "timeout")} {
+		sink.Record(check, []CheckResult{last})
+		sink.Block(check, []CheckResult{last.blocked("kubernetes/coredns")}, "kubernetes/coredns")
+
+		want := 0.0
+		if last.Status == StatusUp {
+			want = 1
+		}
+		if got := gaugeValues(t, up); len(got) != 1 || got[0] != want {
+			t.Errorf("availability of a target blocked after %s = %v, want %v", last.Status, got, want)
+		}
+		if latest := sink.Latest(check.Name); len(latest) != 1 || latest[0].Status != StatusUnknown {
+			t.Errorf("results of a blocked check = %+v, want unknown", latest)
+		}
+	}
+}
+
+// gaugeValues reads the values of the series of a gauge
+func gaugeValues(t *testing.T, gauge *prometheus.GaugeVec) []float64 {
+	t.Helper()
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(gauge)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []float64
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			values = append(values, m.GetGauge().GetValue())
+		}
+	}
+	return values
+}
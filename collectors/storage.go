@@ -2,9 +2,9 @@ package collectors
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"strings"
-	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -16,97 +16,75 @@ import (
 // StorageCollector monitors MinIO/S3 storage health
 type StorageCollector struct {
 	minioConfig config.MinIOConfig
-	interval    time.Duration
-	ctx         context.Context
-	cancel      context.CancelFunc
+	secrets     *SecretResolver
 }
 
 // NewStorageCollector creates a new storage collector
-func NewStorageCollector(cfg *config.Config) *StorageCollector {
-	ctx, cancel := context.WithCancel(context.Background())
+func NewStorageCollector(cfg *config.Config, secrets *SecretResolver) *StorageCollector {
 	return &StorageCollector{
 		minioConfig: cfg.MinIO,
-		interval:    cfg.CollectInterval,
-		ctx:         ctx,
-		cancel:      cancel,
+		secrets:     secrets,
 	}
 }
 
-// Start begins collecting storage metrics
-func (c *StorageCollector) Start() {
-	// Skip if MinIO is not configured
+// Name returns the collector name
+func (c *StorageCollector) Name() string {
+	return "storage"
+}
+
+// Checks returns the MinIO check, or nothing if MinIO is not configured
+func (c *StorageCollector) Checks() []Check {
 	if c.minioConfig.Endpoint == "" {
-		log.Println("MinIO not configured, skipping storage collector...")
-		return
+		return nil
 	}
+	check := newCheck("storage/minio", c.minioConfig.Check, metrics.MinIOUp, single(c.checkMinIO))
+	check.Source = c.minioConfig.Source
+	return []Check{check}
+}
 
+// Start begins collecting storage metrics
+func (c *StorageCollector) Start(ctx context.Context) error {
 	log.Println("Starting storage collector...")
-
-	// Initial check
-	c.collect()
-
-	// Periodic checks
-	ticker := time.NewTicker(c.interval)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				c.collect()
-			case <-c.ctx.Done():
-				ticker.Stop()
-				return
-			}
-		}
-	}()
+	return nil
 }
 
 // Stop stops the collector
-func (c *StorageCollector) Stop() {
+func (c *StorageCollector) Stop(ctx context.Context) error {
 	log.Println("Stopping storage collector...")
-	c.cancel()
-}
-
-// collect performs storage health check
-func (c *StorageCollector) collect() {
-	go c.checkMinIO()
+	return nil
 }
 
 // checkMinIO checks MinIO/S3 health
-func (c *StorageCollector) checkMinIO() {
-	start := time.Now()
-	defer func() {
-		metrics.HealthCheckDuration.WithLabelValues("minio").Observe(time.Since(start).Seconds())
-	}()
+func (c *StorageCollector) checkMinIO(ctx context.Context) CheckResult {
+	result := newResult("minio", c.minioConfig.Endpoint, nil)
+
+	accessKey, err := c.secrets.Resolve(ctx, c.minioConfig.AccessKey)
+	if err != nil {
+		return credentialsUnavailable(result, "MinIO access key", err)
+	}
+	secretKey, err := c.secrets.Resolve(ctx, c.minioConfig.SecretKey)
+	if err != nil {
+		return credentialsUnavailable(result, "MinIO secret key", err)
+	}
 
 	// Create MinIO client
 	minioClient, err := minio.New(c.minioConfig.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(c.minioConfig.AccessKey, c.minioConfig.SecretKey, ""),
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
 		Secure: c.minioConfig.UseSSL,
 	})
 	if err != nil {
-		errorReason := classifyMinIOError(err)
-		log.Printf("Failed to create MinIO client: %v [reason: %s]", err, errorReason)
-		metrics.MinIOUp.WithLabelValues().Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("minio", "client_creation_failed").Inc()
-		return
+		return result.down("client_creation_failed", classifyMinIOError(err),
+			fmt.Sprintf("Failed to create MinIO client: %v", err))
 	}
 
-	// Set timeout for health check
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
 	// Check if MinIO is online by listing buckets
 	_, err = minioClient.ListBuckets(ctx)
 	if err != nil {
-		errorReason := classifyMinIOError(err)
-		log.Printf("MinIO is unreachable: %v [reason: %s]", err, errorReason)
-		metrics.MinIOUp.WithLabelValues().Set(0)
-		metrics.HealthCheckErrors.WithLabelValues("minio", "unreachable").Inc()
-		return
+		return result.down("unreachable", classifyMinIOError(err),
+			fmt.Sprintf("MinIO is unreachable: %v", err))
 	}
 
-	log.Printf("MinIO is healthy")
-	metrics.MinIOUp.WithLabelValues().Set(1)
+	return result.up("MinIO is healthy")
 }
 
 // classifyMinIOError classifies MinIO/S3 errors for better troubleshooting
@@ -0,0 +1,241 @@
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// loadFile overlays the YAML or JSON config file at path onto cfg
+// Fields missing from the file keep their current value
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// YAML is a superset of JSON, so both formats are accepted
+	// Unknown and duplicate fields are rejected, so a typo cannot silently disable a setting
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// duration decodes a time.Duration from a string such as "30s"
+type duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid duration %s, expected a string such as \"30s\"", data)
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", value, err)
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler, decoding the durations from strings
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type plain Config
+	fields := struct {
+		*plain
+		ScrapeTimeout   *duration `json:"scrapeTimeout"`
+		CollectInterval *duration `json:"collectInterval"`
+		ShutdownTimeout *duration `json:"shutdownTimeout"`
+		ReloadInterval  *duration `json:"reloadInterval"`
+	}{
+		plain:           (*plain)(c),
+		ScrapeTimeout:   (*duration)(&c.ScrapeTimeout),
+		CollectInterval: (*duration)(&c.CollectInterval),
+		ShutdownTimeout: (*duration)(&c.ShutdownTimeout),
+		ReloadInterval:  (*duration)(&c.ReloadInterval),
+	}
+	return decodeStrict(data, &fields)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, decoding the interval from a string
+func (d *DiscoveryConfig) UnmarshalJSON(data []byte) error {
+	type plain DiscoveryConfig
+	fields := struct {
+		*plain
+		Interval *duration `json:"interval"`
+	}{
+		plain:    (*plain)(d),
+		Interval: (*duration)(&d.Interval),
+	}
+	return decodeStrict(data, &fields)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting a dsn whose fields are overridden by the
+// explicit fields of the database
+func (db *DatabaseConfig) UnmarshalJSON(data []byte) error {
+	type plain DatabaseConfig
+	fields := struct {
+		*plain
+		DSN string `json:"dsn"`
+	}{
+		plain: (*plain)(db),
+	}
+	if err := decodeStrict(data, &fields); err != nil {
+		return err
+	}
+	if fields.DSN == "" {
+		return nil
+	}
+
+	parsed, err := ParseDSN(fields.DSN)
+	if err != nil {
+		return fmt.Errorf("database %q: %w", db.Name, err)
+	}
+	*db = db.withDSN(parsed)
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler, decoding the durations from strings
+func (c *CheckConfig) UnmarshalJSON(data []byte) error {
+	fields := struct {
+		Interval *duration `json:"interval"`
+		Timeout  *duration `json:"timeout"`
+		Jitter   *duration `json:"jitter"`
+	}{
+		Interval: (*duration)(&c.Interval),
+		Timeout:  (*duration)(&c.Timeout),
+		Jitter:   (*duration)(&c.Jitter),
+	}
+	return decodeStrict(data, &fields)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, decoding the durations from strings
+func (p *DatabasePoolConfig) UnmarshalJSON(data []byte) error {
+	type plain DatabasePoolConfig
+	fields := struct {
+		*plain
+		MaxLifetime *duration `json:"maxLifetime"`
+	}{
+		plain:       (*plain)(p),
+		MaxLifetime: (*duration)(&p.MaxLifetime),
+	}
+	return decodeStrict(data, &fields)
+}
+
+// MarshalJSON implements json.Marshaler, encoding the durations as strings and leaving out the
+// unset ones so that they keep taking the defaults
+func (c CheckConfig) MarshalJSON() ([]byte, error) {
+	format := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return d.String()
+	}
+	return json.Marshal(struct {
+		Interval string `json:"interval,omitempty"`
+		Timeout  string `json:"timeout,omitempty"`
+		Jitter   string `json:"jitter,omitempty"`
+	}{format(c.Interval), format(c.Timeout), format(c.Jitter)})
+}
+
+// decodeStrict decodes JSON rejecting unknown fields
+// Custom unmarshalers are called with a fresh decoder, so the setting has to be applied again
+func decodeStrict(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// withDefaults fills the fields left unset in the config file from defaults
+func (c CheckConfig) withDefaults(defaults CheckConfig) CheckConfig {
+	if c.Interval == 0 {
+		c.Interval = defaults.Interval
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaults.Timeout
+	}
+	if c.Jitter == 0 {
+		c.Jitter = defaults.Jitter
+	}
+	return c
+}
+
+// withDefaults fills the fields left unset in the config file, matching the environment defaults
+func (db DatabaseConfig) withDefaults(check CheckConfig) DatabaseConfig {
+	if db.Port == 0 {
+		db.Port = 3306
+	}
+	if db.Username == "" {
+		db.Username = "root"
+	}
+	db.Role = strings.ToLower(db.Role)
+	if db.Database == "" {
+		// The Rainbond databases are named after their role
+		db.Database = cmp.Or(db.Role, "mysql")
+	}
+	db.TLS.Mode = strings.ToLower(db.TLS.Mode)
+	if db.Heartbeat.Database == "" {
+		db.Heartbeat.Database = DefaultHeartbeatDatabase
+	}
+	db.Check = db.Check.withDefaults(check)
+	db.Source = SourceManual
+	return db
+}
+
+// withDefaults fills the fields left unset in the config file, matching the environment defaults
+func (r RegistryConfig) withDefaults(check CheckConfig) RegistryConfig {
+	r.Check = r.Check.withDefaults(check)
+	r.Source = SourceManual
+	return r
+}
+
+// withDefaults fills the fields left unset in the config file, matching the environment defaults
+func (p ProbeConfig) withDefaults(check CheckConfig) ProbeConfig {
+	p.Type = strings.ToLower(p.Type)
+	if p.Type == "" {
+		p.Type = ProbeTCP
+	}
+	if p.Method == "" {
+		p.Method = "GET"
+	}
+	p.RecordType = strings.ToUpper(p.RecordType)
+	if p.RecordType == "" {
+		p.RecordType = "A"
+	}
+	p.Check = p.Check.withDefaults(check)
+	p.Source = SourceManual
+	return p
+}
+
+// withDefaults fills the fields left unset in the config file, matching the environment defaults
+func (p PluginConfig) withDefaults(check CheckConfig) PluginConfig {
+	p.Check = p.Check.withDefaults(check)
+	return p
+}
+
+// mergeTargets merges targets from the config file with targets from environment variables
+// An environment target replaces the file target of the same name, other targets are appended,
+// so duplicate names within either source are kept for validation to report
+func mergeTargets[T any](file, env []T, name func(T) string) []T {
+	merged := append([]T(nil), file...)
+	replaced := make(map[int]bool)
+	for _, target := range env {
+		i := slices.IndexFunc(file, func(f T) bool { return name(f) == name(target) })
+		if i < 0 || replaced[i] {
+			merged = append(merged, target)
+			continue
+		}
+		merged[i] = target
+		replaced[i] = true
+	}
+	return merged
+}
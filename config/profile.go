@@ -0,0 +1,94 @@
+package config
+
+// ProfileAuto detects the cluster profile from the node labels and the server version
+const ProfileAuto = "auto"
+
+// Etcd strategies
+const (
+	// EtcdPods checks the etcd pods, falling back to the API server if there are none
+	EtcdPods = "pods"
+	// EtcdAPIServer asks the API server about its etcd, for etcd embedded in the API server process
+	EtcdAPIServer = "apiserver"
+	// EtcdNone disables the etcd check, for managed clusters whose etcd is run by the provider
+	EtcdNone = "none"
+)
+
+// ClusterProfile describes where the cluster components of a Kubernetes distribution run
+type ClusterProfile struct {
+	DNSNamespace   string `json:"dnsNamespace"`   // Namespace of the cluster DNS pods
+	DNSSelector    string `json:"dnsSelector"`    // Label selector of the cluster DNS pods
+	EtcdStrategy   string `json:"etcdStrategy"`   // EtcdPods, EtcdAPIServer or EtcdNone
+	EtcdNamespace  string `json:"etcdNamespace"`  // Namespace of the etcd pods, for EtcdPods
+	EtcdSelector   string `json:"etcdSelector"`   // Label selector of the etcd pods, for EtcdPods
+	ProbeNamespace string `json:"probeNamespace"` // Namespace where storage class checks create test PVCs
+}
+
+// Profiles are the known cluster distributions
+var Profiles = map[string]ClusterProfile{
+	"kubeadm": {
+		DNSNamespace:   "kube-system",
+		DNSSelector:    "k8s-app=kube-dns",
+		EtcdStrategy:   EtcdPods,
+		EtcdNamespace:  "kube-system",
+		EtcdSelector:   "component=etcd",
+		ProbeNamespace: DefaultSecretNamespace,
+	},
+	// RKE2 deploys CoreDNS from its rke2-coredns chart and runs etcd as static pods
+	"rke2": {
+		DNSNamespace:   "kube-system",
+		DNSSelector:    "app.kubernetes.io/name=rke2-coredns",
+		EtcdStrategy:   EtcdPods,
+		EtcdNamespace:  "kube-system",
+		EtcdSelector:   "component=etcd,tier=control-plane",
+		ProbeNamespace: DefaultSecretNamespace,
+	},
+	// k3s embeds etcd (or its SQL datastore) in the server process, there are no etcd pods
+	"k3s": {
+		DNSNamespace:   "kube-system",
+		DNSSelector:    "k8s-app=kube-dns",
+		EtcdStrategy:   EtcdAPIServer,
+		ProbeNamespace: DefaultSecretNamespace,
+	},
+	// Managed control planes hide etcd, its health is the provider's responsibility
+	"ack": {
+		DNSNamespace:   "kube-system",
+		DNSSelector:    "k8s-app=kube-dns",
+		EtcdStrategy:   EtcdNone,
+		ProbeNamespace: DefaultSecretNamespace,
+	},
+	"tke": {
+		DNSNamespace:   "kube-system",
+		DNSSelector:    "k8s-app=kube-dns",
+		EtcdStrategy:   EtcdNone,
+		ProbeNamespace: DefaultSecretNamespace,
+	},
+	"eks": {
+		DNSNamespace:   "kube-system",
+		DNSSelector:    "k8s-app=kube-dns",
+		EtcdStrategy:   EtcdNone,
+		ProbeNamespace: DefaultSecretNamespace,
+	},
+}
+
+// DefaultProfile is used when detection does not recognize the distribution
+const DefaultProfile = "kubeadm"
+
+// WithOverrides returns the profile with the non-empty fields of overrides applied
+func (p ClusterProfile) WithOverrides(overrides ClusterProfile) ClusterProfile {
+	for _, field := range []struct {
+		value    *string
+		override string
+	}{
+		{&p.DNSNamespace, overrides.DNSNamespace},
+		{&p.DNSSelector, overrides.DNSSelector},
+		{&p.EtcdStrategy, overrides.EtcdStrategy},
+		{&p.EtcdNamespace, overrides.EtcdNamespace},
+		{&p.EtcdSelector, overrides.EtcdSelector},
+		{&p.ProbeNamespace, overrides.ProbeNamespace},
+	} {
+		if field.override != "" {
+			*field.value = field.override
+		}
+	}
+	return p
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"errors"
+	"log"
+	"slices"
+
+	"sigs.k8s.io/yaml"
+)
+
+// TargetAPIConfig controls the HTTP API managing databases, registries and MinIO at runtime
+// The targets are persisted in a ConfigMap, so they survive restarts
+type TargetAPIConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Namespace string `json:"namespace"` // Namespace of the ConfigMap and the Secret
+	ConfigMap string `json:"configMap"` // Name of the ConfigMap, and of the Secret holding inline credentials
+	Token     Secret `json:"token"`     // Bearer token required by every request
+}
+
+// RuntimeTargets are targets added at runtime, through the target API or HealthCheck resources
+// Check scheduling left unset takes the defaults of the running configuration when applied
+type RuntimeTargets struct {
+	Databases  []DatabaseConfig `json:"databases,omitempty"`
+	Registries []RegistryConfig `json:"registries,omitempty"`
+	Probes     []ProbeConfig    `json:"probes,omitempty"`
+	MinIO      *MinIOConfig     `json:"minio,omitempty"`
+}
+
+// ParseRuntimeTargets decodes runtime targets from YAML or JSON, rejecting unknown fields
+func ParseRuntimeTargets(data []byte) (*RuntimeTargets, error) {
+	targets := &RuntimeTargets{}
+	if err := yaml.UnmarshalStrict(data, targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// Marshal encodes the runtime targets as YAML
+func (t *RuntimeTargets) Marshal() ([]byte, error) {
+	return yaml.Marshal(t)
+}
+
+// Clone returns a copy of the runtime targets whose target lists can be changed independently
+// The targets themselves are shared, they are replaced rather than modified in place
+func (t *RuntimeTargets) Clone() *RuntimeTargets {
+	if t == nil {
+		return &RuntimeTargets{}
+	}
+	clone := &RuntimeTargets{
+		Databases:  slices.Clone(t.Databases),
+		Registries: slices.Clone(t.Registries),
+		Probes:     slices.Clone(t.Probes),
+	}
+	if t.MinIO != nil {
+		minio := *t.MinIO
+		clone.MinIO = &minio
+	}
+	return clone
+}
+
+// Apply adds the runtime targets to cfg marked with source, filling unset check scheduling from
+// the defaults of cfg. Targets already in cfg take precedence over runtime targets of the same
+// name, and a MinIO already in cfg over a runtime one. cfg is left unchanged if the result is
+// invalid
+func (t *RuntimeTargets) Apply(cfg *Config, source string) error {
+	if t == nil {
+		return nil
+	}
+
+	next := *cfg
+	next.Databases = slices.Clone(cfg.Databases)
+	next.Registries = slices.Clone(cfg.Registries)
+	next.Probes = slices.Clone(cfg.Probes)
+
+	for _, db := range t.Databases {
+		if slices.ContainsFunc(cfg.Databases, func(m DatabaseConfig) bool { return m.Name == db.Name }) {
+			log.Printf("Warning: Runtime database %q is ignored, a database of that name is configured", db.Name)
+			continue
+		}
+		db = db.withDefaults(cfg.Checks.Database)
+		db.Source = source
+		next.Databases = append(next.Databases, db)
+	}
+	for _, registry := range t.Registries {
+		if slices.ContainsFunc(cfg.Registries, func(m RegistryConfig) bool { return m.Name == registry.Name }) {
+			log.Printf("Warning: Runtime registry %q is ignored, a registry of that name is configured", registry.Name)
+			continue
+		}
+		registry = registry.withDefaults(cfg.Checks.Registry)
+		registry.Source = source
+		next.Registries = append(next.Registries, registry)
+	}
+	for _, probe := range t.Probes {
+		if slices.ContainsFunc(cfg.Probes, func(m ProbeConfig) bool { return m.Name == probe.Name }) {
+			log.Printf("Warning: Runtime probe %q is ignored, a probe of that name is configured", probe.Name)
+			continue
+		}
+		probe = probe.withDefaults(cfg.Checks.Probe)
+		probe.Source = source
+		next.Probes = append(next.Probes, probe)
+	}
+	if t.MinIO != nil {
+		if cfg.MinIO.Endpoint != "" {
+			log.Println("Warning: Runtime MinIO is ignored, MinIO is configured")
+		} else {
+			minio := *t.MinIO
+			minio.Check = minio.Check.withDefaults(cfg.MinIO.Check)
+			minio.Source = source
+			next.MinIO = minio
+		}
+	}
+
+	if err := errors.Join(next.validate()...); err != nil {
+		return err
+	}
+	*cfg = next
+	return nil
+}
+
+// RedactedSecret returns the secret with an inline value replaced, for display
+func RedactedSecret(s Secret) Secret {
+	if s.Value != "" {
+		s.Value = "<redacted>"
+	}
+	return s
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultSecretNamespace is the namespace of Kubernetes Secret references that do not name one
+const DefaultSecretNamespace = "rbd-system"
+
+// Secret is a credential given inline, read from a file or read from a Kubernetes Secret
+// File and Secret references are resolved on every use, so rotated credentials are picked up
+type Secret struct {
+	Value     string        `json:"value,omitempty"`
+	File      string        `json:"file,omitempty"` // e.g. a key of a mounted Secret volume
+	SecretRef *SecretKeyRef `json:"secretRef,omitempty"`
+}
+
+// SecretKeyRef references a key of a Kubernetes Secret
+type SecretKeyRef struct {
+	Namespace string `json:"namespace,omitempty"` // DefaultSecretNamespace if empty
+	Name      string `json:"name"`
+	Key       string `json:"key"`
+}
+
+// IsSet reports whether the credential is configured in any way
+func (s Secret) IsSet() bool {
+	return s.Value != "" || s.File != "" || s.SecretRef != nil
+}
+
+// String describes where the credential comes from without revealing it
+func (s Secret) String() string {
+	switch {
+	case s.SecretRef != nil:
+		return "secret:" + s.SecretRef.String()
+	case s.File != "":
+		return "file:" + s.File
+	case s.Value != "":
+		return "<redacted>"
+	default:
+		return ""
+	}
+}
+
+// String returns the reference as namespace/name/key
+func (r SecretKeyRef) String() string {
+	return r.Namespace + "/" + r.Name + "/" + r.Key
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+// A plain string is an inline value, otherwise exactly one of value, file or secretRef is expected
+func (s *Secret) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*s = Secret{Value: value}
+		return nil
+	}
+
+	type plain Secret
+	var secret plain
+	if err := decodeStrict(data, &secret); err != nil {
+		return err
+	}
+	*s = Secret(secret)
+	if s.SecretRef != nil && s.SecretRef.Namespace == "" {
+		s.SecretRef.Namespace = DefaultSecretNamespace
+	}
+	return s.validate()
+}
+
+// validate checks that at most one source is configured and that a Secret reference is complete
+func (s Secret) validate() error {
+	sources := 0
+	for _, set := range []bool{s.Value != "", s.File != "", s.SecretRef != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of value, file and secretRef may be set")
+	}
+	if s.SecretRef != nil && (s.SecretRef.Name == "" || s.SecretRef.Key == "") {
+		return fmt.Errorf("secretRef needs a name and a key")
+	}
+	return nil
+}
+
+// parseSecretKeyRef parses a [namespace/]name/key reference
+func parseSecretKeyRef(value string) (*SecretKeyRef, error) {
+	parts := strings.Split(value, "/")
+	ref := &SecretKeyRef{Namespace: DefaultSecretNamespace}
+	switch len(parts) {
+	case 2:
+		ref.Name, ref.Key = parts[0], parts[1]
+	case 3:
+		ref.Namespace, ref.Name, ref.Key = parts[0], parts[1], parts[2]
+	default:
+		return nil, fmt.Errorf("invalid secret reference %q, expected [namespace/]name/key", value)
+	}
+	if ref.Namespace == "" || ref.Name == "" || ref.Key == "" {
+		return nil, fmt.Errorf("invalid secret reference %q, expected [namespace/]name/key", value)
+	}
+	return ref, nil
+}
+
+// getEnvAsSecret loads a credential from key (inline), key_FILE (file path) or
+// key_SECRET ([namespace/]name/key of a Kubernetes Secret), keeping defaultValue if none is set
+func (l *loader) getEnvAsSecret(key string, defaultValue Secret) Secret {
+	secret := Secret{
+		Value: os.Getenv(key),
+		File:  os.Getenv(key + "_FILE"),
+	}
+	if ref := os.Getenv(key + "_SECRET"); ref != "" {
+		parsed, err := parseSecretKeyRef(ref)
+		if err != nil {
+			l.errorf("%s_SECRET: %v", key, err)
+		}
+		secret.SecretRef = parsed
+	}
+
+	if !secret.IsSet() {
+		return defaultValue
+	}
+	if err := secret.validate(); err != nil {
+		l.errorf("%s: only one of %s, %s_FILE and %s_SECRET may be set", key, key, key, key)
+	}
+	return secret
+}
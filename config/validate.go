@@ -0,0 +1,322 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// systemSchemas are the schemas of the MySQL server itself, the heartbeat table is not created in them
+var systemSchemas = []string{"mysql", "information_schema", "performance_schema", "sys"}
+
+// schemaName matches the schema names used without quoting, which keeps them safe to interpolate
+var schemaName = regexp.MustCompile(`^[A-Za-z0-9_$]{1,64}$`)
+
+// recordTypes are the DNS record types supported by DNS probes
+var recordTypes = []string{"A", "AAAA", "CNAME", "TXT", "MX"}
+
+// validate returns every problem of a loaded configuration
+func (c *Config) validate() []error {
+	var errs []error
+	errorf := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.MetricsPort < 1 || c.MetricsPort > 65535 {
+		errorf("metrics port %d is out of range 1-65535", c.MetricsPort)
+	}
+	if c.CollectMode != ModeBackground && c.CollectMode != ModeScrape {
+		errorf("collect mode %q is invalid, expected %s or %s", c.CollectMode, ModeBackground, ModeScrape)
+	}
+	if c.CollectInterval <= 0 {
+		errorf("collect interval must be positive, got %s", c.CollectInterval)
+	}
+	if c.ScrapeTimeout <= 0 {
+		errorf("scrape timeout must be positive, got %s", c.ScrapeTimeout)
+	}
+	if c.ShutdownTimeout <= 0 {
+		errorf("shutdown timeout must be positive, got %s", c.ShutdownTimeout)
+	}
+	if c.ReloadInterval < 0 {
+		errorf("reload interval must not be negative, got %s", c.ReloadInterval)
+	}
+	if c.KubeQPS <= 0 {
+		errorf("kube QPS must be positive, got %g", c.KubeQPS)
+	}
+	if c.KubeBurst < 1 {
+		errorf("kube burst must be at least 1, got %d", c.KubeBurst)
+	}
+	if c.Discovery.Enabled {
+		if c.Discovery.Namespace == "" {
+			errorf("discovery namespace is required")
+		}
+		if c.Discovery.Cluster == "" {
+			errorf("discovery cluster is required")
+		}
+	}
+	if c.Discovery.Interval < 0 {
+		errorf("discovery interval must not be negative, got %s", c.Discovery.Interval)
+	}
+	if c.TargetAPI.Enabled {
+		if c.TargetAPI.Namespace == "" {
+			errorf("target API namespace is required")
+		}
+		if problems := validation.IsDNS1123Subdomain(c.TargetAPI.ConfigMap); len(problems) > 0 {
+			errorf("target API configmap %q is invalid: %s", c.TargetAPI.ConfigMap, strings.Join(problems, ", "))
+		}
+		if !c.TargetAPI.Token.IsSet() {
+			errorf("target API token is required")
+		}
+	}
+	if c.HealthChecks.Enabled && c.HealthChecks.Namespace == "" {
+		errorf("healthcheck namespace is required")
+	}
+
+	errs = append(errs, c.Checks.Database.validate("database checks")...)
+	errs = append(errs, c.Checks.Registry.validate("registry checks")...)
+	errs = append(errs, c.Checks.Probe.validate("probe checks")...)
+	errs = append(errs, c.Checks.Plugin.validate("plugin checks")...)
+	errs = append(errs, c.MinIO.Check.validate("minio check")...)
+	errs = append(errs, c.Kubernetes.APIServer.validate("apiserver check")...)
+	errs = append(errs, c.Kubernetes.CoreDNS.validate("coredns check")...)
+	errs = append(errs, c.Kubernetes.Etcd.validate("etcd check")...)
+	errs = append(errs, c.Kubernetes.StorageClass.validate("storage class check")...)
+
+	if _, ok := Profiles[c.Kubernetes.Profile]; !ok && c.Kubernetes.Profile != ProfileAuto {
+		errorf("kubernetes profile %q is invalid, expected %s or one of %s", c.Kubernetes.Profile, ProfileAuto, strings.Join(profileNames(), ", "))
+	}
+	switch c.Kubernetes.Overrides.EtcdStrategy {
+	case "", EtcdPods, EtcdAPIServer, EtcdNone:
+	default:
+		errorf("etcd strategy %q is invalid, expected one of %s, %s, %s", c.Kubernetes.Overrides.EtcdStrategy, EtcdPods, EtcdAPIServer, EtcdNone)
+	}
+	for _, selector := range []struct{ name, value string }{
+		{"dns selector", c.Kubernetes.Overrides.DNSSelector},
+		{"etcd selector", c.Kubernetes.Overrides.EtcdSelector},
+	} {
+		if _, err := labels.Parse(selector.value); err != nil {
+			errorf("kubernetes %s %q is invalid: %v", selector.name, selector.value, err)
+		}
+	}
+
+	if c.DatabasePool.MaxOpen < 1 {
+		errorf("database pool max open must be at least 1, got %d", c.DatabasePool.MaxOpen)
+	}
+	if c.DatabasePool.MaxIdle < 0 || c.DatabasePool.MaxIdle > c.DatabasePool.MaxOpen {
+		errorf("database pool max idle must be between 0 and max open %d, got %d", c.DatabasePool.MaxOpen, c.DatabasePool.MaxIdle)
+	}
+	if c.DatabasePool.MaxLifetime < 0 {
+		errorf("database pool max lifetime must not be negative, got %s", c.DatabasePool.MaxLifetime)
+	}
+
+	// The heartbeat table must not be created in a Rainbond schema: the role names, which are the
+	// default schema names, and the schemas of the instances with a role
+	rainbondSchemas := make(map[string]bool)
+	for _, role := range databaseRoles {
+		rainbondSchemas[role] = true
+	}
+	for _, db := range c.Databases {
+		if db.Role != "" {
+			rainbondSchemas[strings.ToLower(db.Database)] = true
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, db := range c.Databases {
+		what := fmt.Sprintf("database %q", db.Name)
+		checkName(&errs, names, "database", db.Name)
+		if db.Host == "" {
+			errorf("%s: host or dsn is required", what)
+		}
+		if db.Port < 1 || db.Port > 65535 {
+			errorf("%s: port %d is out of range 1-65535", what, db.Port)
+		}
+		// Only the parameters are parsed, the driver rejects unknown parameters and invalid values
+		params := maps.Clone(db.Params)
+		if value, ok := params["tls"]; ok {
+			// The driver only knows TLS configs registered under a name, none is
+			if _, ok := tlsParamMode(value); !ok {
+				errorf("%s: tls param %q names no TLS config, use true, false, skip-verify or preferred, or set the tls mode, ca, cert and key", what, value)
+			}
+			delete(params, "tls")
+		}
+		if len(params) > 0 {
+			if _, err := mysql.ParseDSN("/?" + formatParams(params)); err != nil {
+				errorf("%s: invalid params: %v", what, err)
+			}
+		}
+		errs = append(errs, db.TLS.validate(what, db.Params)...)
+		if db.Role != "" {
+			if !slices.Contains(databaseRoles, db.Role) {
+				errorf("%s: role %q is invalid, expected one of %s", what, db.Role, strings.Join(databaseRoles, ", "))
+			} else if slices.Contains(systemSchemas, strings.ToLower(db.Database)) {
+				errorf("%s: role %s requires the database of the %s schema, got %s", what, db.Role, db.Role, db.Database)
+			}
+		}
+		if db.Heartbeat.Enabled {
+			if err := validateSchemaName(db.Heartbeat.Database); err != nil {
+				errorf("%s: heartbeat database: %v", what, err)
+			} else if rainbondSchemas[strings.ToLower(db.Heartbeat.Database)] {
+				errorf("%s: heartbeat database %q is a Rainbond schema, use a dedicated one such as %s", what, db.Heartbeat.Database, DefaultHeartbeatDatabase)
+			}
+		}
+		errs = append(errs, db.Check.validate(what)...)
+	}
+
+	names = make(map[string]bool)
+	for _, registry := range c.Registries {
+		what := fmt.Sprintf("registry %q", registry.Name)
+		checkName(&errs, names, "registry", registry.Name)
+		if registry.URL == "" {
+			errorf("%s: url is required", what)
+		} else if scheme, _, found := strings.Cut(registry.URL, "://"); found && scheme != "http" && scheme != "https" && scheme != "oci" {
+			errorf("%s: url %q has unknown scheme %q, expected http, https, oci or no scheme", what, registry.URL, scheme)
+		}
+		errs = append(errs, registry.Check.validate(what)...)
+	}
+
+	names = make(map[string]bool)
+	for _, probe := range c.Probes {
+		what := fmt.Sprintf("probe %q", probe.Name)
+		checkName(&errs, names, "probe", probe.Name)
+		if probe.Target == "" {
+			errorf("%s: target is required", what)
+		}
+		switch probe.Type {
+		case ProbeTCP, ProbeTLS:
+		case ProbeHTTP:
+			if target, err := url.Parse(probe.Target); err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+				errorf("%s: target %q must be an http or https URL", what, probe.Target)
+			}
+			for _, status := range probe.ExpectedStatus {
+				if status < 100 || status > 599 {
+					errorf("%s: expected status %d is not an HTTP status code", what, status)
+				}
+			}
+		case ProbeDNS:
+			if !slices.Contains(recordTypes, probe.RecordType) {
+				errorf("%s: record type %q is invalid, expected one of %s", what, probe.RecordType, strings.Join(recordTypes, ", "))
+			}
+		default:
+			errorf("%s: type %q is invalid, expected one of %s, %s, %s, %s", what, probe.Type, ProbeTCP, ProbeHTTP, ProbeDNS, ProbeTLS)
+		}
+		errs = append(errs, probe.Check.validate(what)...)
+	}
+
+	names = make(map[string]bool)
+	for _, plugin := range c.Plugins {
+		what := fmt.Sprintf("plugin %q", plugin.Name)
+		checkName(&errs, names, "plugin", plugin.Name)
+		if plugin.Command == "" {
+			errorf("%s: command is required", what)
+		}
+		errs = append(errs, plugin.Check.validate(what)...)
+	}
+	if c.PluginMaxConcurrency < 1 {
+		errorf("plugin max concurrency must be at least 1, got %d", c.PluginMaxConcurrency)
+	}
+	if c.PluginMaxOutputBytes < 1 {
+		errorf("plugin max output bytes must be at least 1, got %d", c.PluginMaxOutputBytes)
+	}
+
+	// MinIO is optional, but only as a whole
+	if minio := c.MinIO; minio.Endpoint != "" || minio.AccessKey.IsSet() || minio.SecretKey.IsSet() {
+		if minio.Endpoint == "" {
+			errorf("minio is partially configured: endpoint is missing")
+		}
+		if !minio.AccessKey.IsSet() {
+			errorf("minio is partially configured: access key is missing")
+		}
+		if !minio.SecretKey.IsSet() {
+			errorf("minio is partially configured: secret key is missing")
+		}
+	}
+	return errs
+}
+
+// validate returns the problems of the TLS settings of the database described by what
+func (t DatabaseTLSConfig) validate(what string, params map[string]string) []error {
+	var errs []error
+	errorf := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{what}, args...)...))
+	}
+
+	switch {
+	case t.Mode == "":
+		if t.CA.IsSet() || t.Cert.IsSet() || t.Key.IsSet() || t.ServerName != "" {
+			errorf("tls mode is required with a tls ca, cert, key or server name")
+		}
+		return errs
+	case !slices.Contains(tlsModes, t.Mode):
+		errorf("tls mode %q is invalid, expected one of %s", t.Mode, strings.Join(tlsModes, ", "))
+	}
+	if _, ok := params["tls"]; ok {
+		errorf("the tls param conflicts with tls mode %s, remove one of them", t.Mode)
+	}
+	if t.Mode == TLSDisabled && (t.CA.IsSet() || t.Cert.IsSet() || t.Key.IsSet()) {
+		errorf("tls ca, cert and key are not used in tls mode %s", t.Mode)
+	} else if t.CA.IsSet() && t.Mode != TLSVerifyCA && t.Mode != TLSVerifyFull {
+		errorf("tls ca is only used in tls modes %s and %s", TLSVerifyCA, TLSVerifyFull)
+	}
+	if t.ServerName != "" && t.Mode != TLSVerifyFull {
+		errorf("tls server name is only used in tls mode %s", TLSVerifyFull)
+	}
+	if t.Cert.IsSet() != t.Key.IsSet() {
+		errorf("tls cert and key must be set together")
+	}
+	return errs
+}
+
+// validate returns the problems of the scheduling of the check described by what
+func (c CheckConfig) validate(what string) []error {
+	var errs []error
+	if c.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("%s: interval must be positive, got %s", what, c.Interval))
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("%s: timeout must be positive, got %s", what, c.Timeout))
+	}
+	if c.Jitter < 0 {
+		errs = append(errs, fmt.Errorf("%s: jitter must not be negative, got %s", what, c.Jitter))
+	}
+	return errs
+}
+
+// profileNames returns the names of the known cluster profiles, sorted
+func profileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// checkName reports a missing or duplicate instance name, names are used as metric labels
+func checkName(errs *[]error, names map[string]bool, kind, name string) {
+	switch {
+	case name == "":
+		*errs = append(*errs, fmt.Errorf("%s without a name", kind))
+	case names[name]:
+		*errs = append(*errs, fmt.Errorf("duplicate %s name %q", kind, name))
+	}
+	names[name] = true
+}
+
+// validateSchemaName checks the schema of the heartbeat table
+func validateSchemaName(name string) error {
+	if !schemaName.MatchString(name) {
+		return fmt.Errorf("%q is invalid, expected up to 64 letters, digits, _ or $", name)
+	}
+	if slices.Contains(systemSchemas, strings.ToLower(name)) {
+		return fmt.Errorf("%q is a system schema", name)
+	}
+	return nil
+}
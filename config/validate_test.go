@@ -0,0 +1,133 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns the default configuration, which is valid
+func validConfig(t *testing.T) *Config {
+	t.Helper()
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() with the defaults = %v", err)
+	}
+	return cfg
+}
+
+func TestValidateDefaults(t *testing.T) {
+	if errs := validConfig(t).validate(); len(errs) != 0 {
+		t.Errorf("validate() of the defaults = %v, want no problems", errs)
+	}
+}
+
+func TestValidateCollectsEveryProblem(t *testing.T) {
+	check := CheckConfig{Interval: 30 * time.Second, Timeout: 5 * time.Second}
+	cfg := validConfig(t)
+	cfg.MetricsPort = 0
+	cfg.CollectMode = "push"
+	cfg.DatabasePool.MaxIdle = 5
+	cfg.Databases = []DatabaseConfig{
+		{Name: "console", Host: "h", Port: 3306, Database: "console", Role: RoleConsole, Check: check},
+		{Name: "console", Port: 70000, Params: map[string]string{"tls": "custom"}, Check: CheckConfig{Timeout: time.Second}},
+		{Name: "region", Host: "h", Port: 3306, Database: "mysql", Role: RoleRegion, Check: check},
+	}
+	cfg.Probes = []ProbeConfig{
+		{Name: "web", Type: ProbeHTTP, Target: "ftp://web", ExpectedStatus: []int{42}, Check: check},
+		{Name: "dns", Type: ProbeDNS, Target: "example.com", RecordType: "SRV", Check: check},
+	}
+	cfg.Plugins = []PluginConfig{{Check: check}}
+	cfg.MinIO.Endpoint = "minio:9000"
+
+	want := []string{
+		"metrics port 0 is out of range",
+		`collect mode "push" is invalid`,
+		"database pool max idle must be between 0 and max open 2, got 5",
+		`duplicate database name "console"`,
+		`database "console": host or dsn is required`,
+		`database "console": port 70000 is out of range`,
+		`database "console": tls param "custom" names no TLS config`,
+		`database "console": interval must be positive`,
+		`database "region": role region requires the database of the region schema, got mysql`,
+		`probe "web": target "ftp://web" must be an http or https URL`,
+		`probe "web": expected status 42 is not an HTTP status code`,
+		`probe "dns": record type "SRV" is invalid`,
+		"plugin without a name",
+		`plugin "": command is required`,
+		"minio is partially configured: access key is missing",
+		"minio is partially configured: secret key is missing",
+	}
+
+	errs := cfg.validate()
+	for _, w := range want {
+		found := false
+		for _, err := range errs {
+			found = found || strings.Contains(err.Error(), w)
+		}
+		if !found {
+			t.Errorf("validate() did not report %q", w)
+		}
+	}
+	if len(errs) != len(want) {
+		t.Errorf("validate() reported %d problems, want %d:\n%v", len(errs), len(want), errs)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	t.Setenv("METRICS_PORT", "http")
+	t.Setenv("COLLECT_MODE", "push")
+	t.Setenv("PLUGIN_MAX_CONCURRENCY", "0")
+
+	_, err := Load("")
+	if err == nil {
+		t.Fatal("Load() accepted an invalid configuration")
+	}
+	for _, want := range []string{"METRICS_PORT", `collect mode "push" is invalid`, "plugin max concurrency must be at least 1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Load() = %q, want containing %q", err, want)
+		}
+	}
+	// Every problem is reported on its own line
+	if strings.Count(err.Error(), "\n")+1 < 3 {
+		t.Errorf("Load() = %q, want one problem per line", err)
+	}
+}
+
+func TestValidateHeartbeatDatabase(t *testing.T) {
+	check := CheckConfig{Interval: 30 * time.Second, Timeout: 5 * time.Second}
+	tests := []struct {
+		database string
+		wantErr  string
+	}{
+		{database: DefaultHeartbeatDatabase},
+		{database: "monitoring"},
+		{database: "mysql", wantErr: "is a system schema"},
+		{database: "health-console", wantErr: "is invalid"},
+		{database: "console", wantErr: `"console" is a Rainbond schema`},
+		{database: "Region", wantErr: `"Region" is a Rainbond schema`},
+		// The schema of the instance with a role, named differently than the role
+		{database: "rainbond_console", wantErr: `"rainbond_console" is a Rainbond schema`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.database, func(t *testing.T) {
+			cfg := validConfig(t)
+			cfg.Databases = []DatabaseConfig{
+				{Name: "console", Host: "h", Port: 3306, Database: "rainbond_console", Role: RoleConsole, Check: check},
+				{Name: "writes", Host: "h", Port: 3306, Database: "mysql", Heartbeat: DatabaseHeartbeatConfig{Enabled: true, Database: tt.database}, Check: check},
+			}
+
+			errs := cfg.validate()
+			if tt.wantErr == "" {
+				if len(errs) != 0 {
+					t.Errorf("validate() = %v, want no problems", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+				t.Errorf("validate() = %v, want one problem containing %q", errs, tt.wantErr)
+			}
+		})
+	}
+}
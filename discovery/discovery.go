@@ -0,0 +1,292 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/rainbond/health-console/config"
+	"github.com/rainbond/health-console/kube"
+)
+
+// rainbondClusters is the resource of the RainbondCluster custom resource of rainbond-operator
+var rainbondClusters = schema.GroupVersionResource{Group: "rainbond.io", Version: "v1alpha1", Resource: "rainbondclusters"}
+
+// Dependencies installed by rainbond-operator when the RainbondCluster does not name external ones
+const (
+	// builtinDatabaseService serves both the region and the console database
+	builtinDatabaseService = "rbd-db-rw"
+	// builtinDatabaseSecret holds the credentials of the builtin database
+	builtinDatabaseSecret = "rbd-db"
+
+	// builtinHubDomain is the registry domain nodes resolve to rbd-hub, pods cannot resolve it
+	builtinHubDomain = "goodrain.me"
+	// builtinHubService serves the builtin registry
+	builtinHubService = "rbd-hub"
+	// builtinHubSecret holds the credentials of the builtin registry
+	builtinHubSecret = "rbd-hub-credentials"
+
+	// minioService serves MinIO
+	minioService = "minio-service"
+	// minioSecret holds the MinIO credentials, MinIO is not discovered without it
+	minioSecret = "rbd-minio"
+)
+
+// rainbondCluster is the part of the RainbondCluster resource describing the dependencies
+type rainbondCluster struct {
+	Spec struct {
+		RegionDatabase *database `json:"regionDatabase,omitempty"`
+		UIDatabase     *database `json:"uiDatabase,omitempty"`
+		ImageHub       *imageHub `json:"imageHub,omitempty"`
+	} `json:"spec"`
+}
+
+// database is an external database of a RainbondCluster
+type database struct {
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+// imageHub is the registry of a RainbondCluster
+type imageHub struct {
+	Domain   string `json:"domain,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// Targets are the dependencies of a Rainbond installation found in the cluster
+type Targets struct {
+	Databases  []config.DatabaseConfig
+	Registries []config.RegistryConfig
+	MinIO      *config.MinIOConfig // nil if MinIO was not found
+}
+
+// Apply adds the discovered targets to cfg
+// Manually configured targets take precedence over discovered targets of the same name, and a
+// manually configured MinIO over a discovered one
+func (t *Targets) Apply(cfg *config.Config) {
+	if t == nil {
+		return
+	}
+
+	for _, db := range t.Databases {
+		if !slices.ContainsFunc(cfg.Databases, func(m config.DatabaseConfig) bool { return m.Name == db.Name }) {
+			cfg.Databases = append(cfg.Databases, db)
+		}
+	}
+	for _, registry := range t.Registries {
+		if !slices.ContainsFunc(cfg.Registries, func(m config.RegistryConfig) bool { return m.Name == registry.Name }) {
+			cfg.Registries = append(cfg.Registries, registry)
+		}
+	}
+	if t.MinIO != nil && cfg.MinIO.Endpoint == "" {
+		cfg.MinIO = *t.MinIO
+	}
+}
+
+// Count returns the number of discovered targets
+func (t *Targets) Count() int {
+	if t == nil {
+		return 0
+	}
+	n := len(t.Databases) + len(t.Registries)
+	if t.MinIO != nil {
+		n++
+	}
+	return n
+}
+
+// Discoverer finds the dependencies of a Rainbond installation
+type Discoverer struct {
+	clients *kube.Clients
+}
+
+// New creates a new discoverer reading the cluster through clients
+func New(clients *kube.Clients) *Discoverer {
+	return &Discoverer{clients: clients}
+}
+
+// Discover reads the RainbondCluster resource and the Secrets of rainbond-operator
+// Discovered credentials kept in Secrets are referenced rather than copied, so they are read
+// again on every check run. The check scheduling defaults of cfg apply to the discovered targets
+func (d *Discoverer) Discover(ctx context.Context, cfg *config.Config) (*Targets, error) {
+	dynamicClient, err := d.clients.Dynamic()
+	if err != nil {
+		return nil, err
+	}
+	namespace := cfg.Discovery.Namespace
+
+	obj, err := dynamicClient.Resource(rainbondClusters).Namespace(namespace).Get(ctx, cfg.Discovery.Cluster, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get RainbondCluster %s/%s: %w", namespace, cfg.Discovery.Cluster, err)
+	}
+	var cluster rainbondCluster
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &cluster); err != nil {
+		return nil, fmt.Errorf("failed to parse RainbondCluster %s/%s: %w", namespace, cfg.Discovery.Cluster, err)
+	}
+
+	targets := &Targets{}
+
+	databases, err := d.discoverDatabases(ctx, cfg, cluster)
+	if err != nil {
+		return nil, err
+	}
+	targets.Databases = databases
+
+	registry, err := d.discoverRegistry(ctx, cfg, cluster)
+	if err != nil {
+		return nil, err
+	}
+	targets.Registries = []config.RegistryConfig{registry}
+
+	minio, err := d.discoverMinIO(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	targets.MinIO = minio
+
+	return targets, nil
+}
+
+// discoverDatabases returns the region and console databases
+// Databases the RainbondCluster does not name are served by the builtin database
+func (d *Discoverer) discoverDatabases(ctx context.Context, cfg *config.Config, cluster rainbondCluster) ([]config.DatabaseConfig, error) {
+	namespace := cfg.Discovery.Namespace
+	specs := []struct {
+		name     string
+		database string // Named after its role
+		external *database
+	}{
+		{"rbd-db-region", config.RoleRegion, cluster.Spec.RegionDatabase},
+		{"rbd-db-console", config.RoleConsole, cluster.Spec.UIDatabase},
+	}
+
+	var databases []config.DatabaseConfig
+	for _, spec := range specs {
+		db := config.DatabaseConfig{
+			Name:     spec.name,
+			Port:     3306,
+			Username: "root",
+			Database: spec.database,
+			Check:    cfg.Checks.Database,
+			Source:   config.SourceDiscovered,
+		}
+		if cfg.Discovery.VerifySchema {
+			db.Role = spec.database
+		}
+
+		if external := spec.external; external != nil && external.Host != "" {
+			db.Host = external.Host
+			if external.Port != 0 {
+				db.Port = external.Port
+			}
+			if external.Username != "" {
+				db.Username = external.Username
+			}
+			if external.Name != "" {
+				db.Database = external.Name
+			}
+			db.Password = config.Secret{Value: external.Password}
+			databases = append(databases, db)
+			continue
+		}
+
+		secret, err := d.secret(ctx, namespace, builtinDatabaseSecret)
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			log.Printf("Discovery: Secret %s/%s not found, skipping builtin %s database", namespace, builtinDatabaseSecret, spec.database)
+			continue
+		}
+		db.Host = builtinDatabaseService + "." + namespace
+		if user := string(secret["mysql-user"]); user != "" {
+			db.Username = user
+		}
+		db.Password = config.Secret{SecretRef: &config.SecretKeyRef{Namespace: namespace, Name: builtinDatabaseSecret, Key: "mysql-password"}}
+		databases = append(databases, db)
+	}
+	return databases, nil
+}
+
+// discoverRegistry returns the image registry
+// The builtin registry is used unless the RainbondCluster names an external one
+func (d *Discoverer) discoverRegistry(ctx context.Context, cfg *config.Config, cluster rainbondCluster) (config.RegistryConfig, error) {
+	namespace := cfg.Discovery.Namespace
+	registry := config.RegistryConfig{
+		Name:   "rbd-hub",
+		Check:  cfg.Checks.Registry,
+		Source: config.SourceDiscovered,
+	}
+
+	if hub := cluster.Spec.ImageHub; hub != nil && hub.Domain != "" && hub.Domain != builtinHubDomain {
+		registry.URL = hub.Domain
+		registry.Username = hub.Username
+		registry.Password = config.Secret{Value: hub.Password}
+		return registry, nil
+	}
+
+	registry.URL = builtinHubService + "." + namespace + ":5000"
+	registry.Insecure = true
+	if hub := cluster.Spec.ImageHub; hub != nil && hub.Username != "" {
+		registry.Username = hub.Username
+		registry.Password = config.Secret{Value: hub.Password}
+		return registry, nil
+	}
+
+	secret, err := d.secret(ctx, namespace, builtinHubSecret)
+	if err != nil {
+		return registry, err
+	}
+	if secret != nil {
+		registry.Username = string(secret["username"])
+		registry.Password = config.Secret{SecretRef: &config.SecretKeyRef{Namespace: namespace, Name: builtinHubSecret, Key: "password"}}
+	}
+	return registry, nil
+}
+
+// discoverMinIO returns MinIO, or nil if its Secret does not exist
+func (d *Discoverer) discoverMinIO(ctx context.Context, cfg *config.Config) (*config.MinIOConfig, error) {
+	namespace := cfg.Discovery.Namespace
+	secret, err := d.secret(ctx, namespace, minioSecret)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+
+	endpoint := minioService + "." + namespace + ":9000"
+	if value := string(secret["endpoint"]); value != "" {
+		endpoint = value
+	}
+	return &config.MinIOConfig{
+		Endpoint:  endpoint,
+		AccessKey: config.Secret{SecretRef: &config.SecretKeyRef{Namespace: namespace, Name: minioSecret, Key: "MINIO_ROOT_USER"}},
+		SecretKey: config.Secret{SecretRef: &config.SecretKeyRef{Namespace: namespace, Name: minioSecret, Key: "MINIO_ROOT_PASSWORD"}},
+		Check:     cfg.MinIO.Check,
+		Source:    config.SourceDiscovered,
+	}, nil
+}
+
+// secret returns the data of a Secret, or nil if it does not exist
+func (d *Discoverer) secret(ctx context.Context, namespace, name string) (map[string][]byte, error) {
+	clientset, err := d.clients.Kubernetes()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Secret %s/%s: %w", namespace, name, err)
+	}
+	return secret.Data, nil
+}
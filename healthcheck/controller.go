@@ -0,0 +1,284 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/rainbond/health-console/collectors"
+	"github.com/rainbond/health-console/config"
+	"github.com/rainbond/health-console/kube"
+)
+
+// Resource is the HealthCheck custom resource, see deploy/kubernetes/healthcheck-crd.yaml
+var Resource = schema.GroupVersionResource{Group: "health.rainbond.io", Version: "v1alpha1", Resource: "healthchecks"}
+
+const (
+	// syncTimeout bounds waiting for the initial list of HealthChecks
+	syncTimeout = 30 * time.Second
+	// debounce groups the changes of several HealthChecks, such as a kubectl apply, into one reload
+	debounce = time.Second
+	// statusInterval is how often the latest results are written to the HealthCheck statuses
+	statusInterval = 10 * time.Second
+	// statusTimeout bounds a single status update
+	statusTimeout = 10 * time.Second
+)
+
+// Controller watches the HealthCheck resources of a namespace, adds their targets to the
+// configuration and writes the latest result of their checks to their status
+type Controller struct {
+	client    dynamic.Interface
+	namespace string
+	informer  cache.SharedIndexInformer
+	changed   chan struct{} // Signalled when a HealthCheck is added, deleted or its spec changes
+
+	mu     sync.Mutex
+	states map[string]state // Applied HealthChecks by name
+}
+
+// state is how a generation of a HealthCheck was applied
+type state struct {
+	generation int64
+	check      string // Name of the scheduled check, empty if the HealthCheck is invalid
+	problem    string // Why the HealthCheck is not checked
+}
+
+// NewController creates a controller for the HealthChecks of the namespace configured in cfg
+func NewController(clients *kube.Clients, cfg config.HealthCheckConfig) (*Controller, error) {
+	client, err := clients.Dynamic()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Controller{
+		client:    client,
+		namespace: cfg.Namespace,
+		informer:  dynamicinformer.NewFilteredDynamicInformer(client, Resource, cfg.Namespace, 0, cache.Indexers{}, nil).Informer(),
+		changed:   make(chan struct{}, 1),
+	}
+	_, err = c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(any) { c.notify() },
+		UpdateFunc: func(old, new any) {
+			// Status updates, including ours, do not change the generation
+			if old.(*unstructured.Unstructured).GetGeneration() != new.(*unstructured.Unstructured).GetGeneration() {
+				c.notify()
+			}
+		},
+		DeleteFunc: func(any) { c.notify() },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch HealthChecks: %w", err)
+	}
+	return c, nil
+}
+
+// String returns the namespace of the HealthChecks
+func (c *Controller) String() string {
+	return c.namespace
+}
+
+// Start watches the HealthChecks until ctx is cancelled and waits for the initial list
+// If they cannot be listed in time the controller starts without them and reports them as they
+// are listed, through Run
+func (c *Controller) Start(ctx context.Context) error {
+	go c.informer.Run(ctx.Done())
+
+	syncCtx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), c.informer.HasSynced) {
+		return fmt.Errorf("timed out listing HealthChecks in namespace %s, is the CRD installed?", c.namespace)
+	}
+	// The initial list is applied by the caller
+	select {
+	case <-c.changed:
+	default:
+	}
+	return nil
+}
+
+// Apply adds the targets of the valid HealthChecks to cfg, marked with config.SourceHealthCheck
+// Targets already in cfg take precedence. A HealthCheck that is invalid or names a target already
+// in cfg is left out and reported in its status, without affecting the others
+func (c *Controller) Apply(cfg *config.Config) error {
+	if c == nil {
+		return nil
+	}
+
+	valid := &config.RuntimeTargets{}
+	states := make(map[string]state)
+	for _, obj := range c.list() {
+		st := state{generation: obj.GetGeneration()}
+		targets, check, err := convert(obj)
+		switch {
+		case err != nil:
+			st.problem = err.Error()
+		case configured(cfg, check):
+			st.problem = fmt.Sprintf("check %s is already configured, the configured target takes precedence", check)
+		default:
+			// Apply leaves the copy unchanged, it only tells whether this HealthCheck is valid
+			trial := *cfg
+			if err := targets.Apply(&trial, config.SourceHealthCheck); err != nil {
+				st.problem = strings.ReplaceAll(err.Error(), "\n", "; ")
+				break
+			}
+			st.check = check
+			valid.Databases = append(valid.Databases, targets.Databases...)
+			valid.Registries = append(valid.Registries, targets.Registries...)
+			valid.Probes = append(valid.Probes, targets.Probes...)
+		}
+		if st.problem != "" {
+			log.Printf("Warning: HealthCheck %s/%s is not checked: %s", c.namespace, obj.GetName(), st.problem)
+		}
+		states[obj.GetName()] = st
+	}
+
+	if err := valid.Apply(cfg, config.SourceHealthCheck); err != nil {
+		return fmt.Errorf("invalid HealthCheck targets: %w", err)
+	}
+	c.mu.Lock()
+	c.states = states
+	c.mu.Unlock()
+	return nil
+}
+
+// Run reloads with reload when HealthChecks change and writes the latest results of their checks
+// from sink to their status, until ctx is cancelled
+func (c *Controller) Run(ctx context.Context, sink *collectors.Sink, reload func(trigger string)) {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.changed:
+			select {
+			case <-time.After(debounce):
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case <-c.changed:
+			default:
+			}
+			reload("HealthCheck changed")
+			c.updateStatuses(ctx, sink)
+		case <-ticker.C:
+			c.updateStatuses(ctx, sink)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notify signals a change without blocking, a pending signal covers later changes
+func (c *Controller) notify() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// list returns the HealthChecks in the cache, sorted by name
+func (c *Controller) list() []*unstructured.Unstructured {
+	var objs []*unstructured.Unstructured
+	for _, obj := range c.informer.GetStore().List() {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			objs = append(objs, u)
+		}
+	}
+	slices.SortFunc(objs, func(a, b *unstructured.Unstructured) int { return strings.Compare(a.GetName(), b.GetName()) })
+	return objs
+}
+
+// updateStatuses writes the status of every applied HealthCheck whose result changed
+// HealthChecks changed since the last Apply are skipped until they are applied
+func (c *Controller) updateStatuses(ctx context.Context, sink *collectors.Sink) {
+	c.mu.Lock()
+	states := c.states
+	c.mu.Unlock()
+
+	for _, obj := range c.list() {
+		st, ok := states[obj.GetName()]
+		if !ok || st.generation != obj.GetGeneration() {
+			continue
+		}
+		var current status
+		if content, ok := obj.Object["status"].(map[string]any); ok {
+			// A status that cannot be decoded is replaced
+			_ = runtime.DefaultUnstructuredConverter.FromUnstructured(content, &current)
+		}
+
+		next := st.status(sink)
+		if next.Result == current.Result {
+			next.LastTransitionTime = current.LastTransitionTime
+		} else {
+			next.LastTransitionTime = time.Now().UTC().Format(time.RFC3339)
+		}
+		if next == current {
+			continue
+		}
+		if err := c.writeStatus(ctx, obj, next); err != nil {
+			// A conflict means the HealthCheck changed, the next round writes the status again
+			log.Printf("Warning: Failed to update status of HealthCheck %s/%s: %v", c.namespace, obj.GetName(), err)
+		}
+	}
+}
+
+// writeStatus replaces the status of a HealthCheck
+func (c *Controller) writeStatus(ctx context.Context, obj *unstructured.Unstructured, s status) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&s)
+	if err != nil {
+		return err
+	}
+	obj = obj.DeepCopy()
+	obj.Object["status"] = content
+
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+	_, err = c.client.Resource(Resource).Namespace(c.namespace).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
+
+// status returns the status of a HealthCheck applied as st, from the latest result of its check
+func (st state) status(sink *collectors.Sink) status {
+	s := status{ObservedGeneration: st.generation, Check: st.check}
+	if st.problem != "" {
+		s.Result = ResultInvalid
+		s.Reason = "配置无效"
+		s.Message = st.problem
+		return s
+	}
+
+	results := sink.Latest(st.check)
+	if len(results) == 0 {
+		s.Result = ResultPending
+		s.Message = "Waiting for the first run of the check"
+		return s
+	}
+	r := results[0]
+	s.Result = string(r.Status)
+	s.Reason = r.Reason
+	s.ErrorType = r.ErrorType
+	s.Message = r.Message
+	return s
+}
+
+// convert decodes a HealthCheck and returns its runtime target and the name of its check
+func convert(obj *unstructured.Unstructured) (*config.RuntimeTargets, string, error) {
+	var hc healthCheck
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &hc); err != nil {
+		return nil, "", fmt.Errorf("failed to decode HealthCheck: %w", err)
+	}
+	return hc.targets()
+}
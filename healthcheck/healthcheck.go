@@ -0,0 +1,174 @@
+package healthcheck
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rainbond/health-console/config"
+)
+
+// Types of HealthCheck targets, the probe types are those of config.ProbeConfig
+const (
+	TypeDatabase = "database"
+	TypeRegistry = "registry"
+)
+
+// types lists the valid HealthCheck types
+var types = []string{TypeDatabase, TypeRegistry, config.ProbeTCP, config.ProbeHTTP, config.ProbeDNS, config.ProbeTLS}
+
+// Results written to the status besides the check statuses up, down and unknown
+const (
+	// ResultPending means the check has not run yet
+	ResultPending = "pending"
+	// ResultInvalid means the HealthCheck is not checked, the message tells why
+	ResultInvalid = "invalid"
+)
+
+// healthCheck is a HealthCheck resource
+type healthCheck struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec spec `json:"spec"`
+}
+
+// spec describes the target of a HealthCheck and how it is checked
+type spec struct {
+	Type     string `json:"type"`
+	Target   string `json:"target"`             // DSN for database, URL for registry and http, host:port for tcp and tls, hostname for dns
+	Interval string `json:"interval,omitempty"` // Check defaults of the type if empty
+	Timeout  string `json:"timeout,omitempty"`
+	Severity string `json:"severity,omitempty"` // critical, warning or info, shown by kubectl get healthchecks
+
+	// Credentials of database and registry targets, the Secret is in the namespace of the HealthCheck
+	Username          string        `json:"username,omitempty"`
+	PasswordSecretRef *secretKeyRef `json:"passwordSecretRef,omitempty"`
+
+	// Options of registry and probe targets, see config.ProbeConfig
+	Insecure        bool              `json:"insecure,omitempty"`
+	Method          string            `json:"method,omitempty"`
+	ExpectedStatus  []int             `json:"expectStatus,omitempty"`
+	ExpectedBody    string            `json:"expectBody,omitempty"`
+	ExpectedHeaders map[string]string `json:"expectHeaders,omitempty"`
+	RecordType      string            `json:"recordType,omitempty"`
+	DNSServer       string            `json:"dnsServer,omitempty"`
+	ExpectedAnswers []string          `json:"expectAnswers,omitempty"`
+	ServerName      string            `json:"serverName,omitempty"`
+}
+
+// secretKeyRef references a key of a Secret in the namespace of the HealthCheck
+type secretKeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// status is the latest result of a HealthCheck
+type status struct {
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	Check              string `json:"check,omitempty"`  // Name of the scheduled check, as in /checks and /results
+	Result             string `json:"result,omitempty"` // up, down, unknown, ResultPending or ResultInvalid
+	Reason             string `json:"reason,omitempty"`
+	ErrorType          string `json:"errorType,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"` // When Result last changed, RFC 3339
+}
+
+// targets converts the HealthCheck to the runtime target it describes and returns the name of its
+// check. The target is named after the HealthCheck
+func (hc *healthCheck) targets() (*config.RuntimeTargets, string, error) {
+	name := hc.Metadata.Name
+	s := hc.Spec
+	if s.Target == "" {
+		return nil, "", errors.New("target is required")
+	}
+
+	var check config.CheckConfig
+	var err error
+	if check.Interval, err = parseDuration("interval", s.Interval); err != nil {
+		return nil, "", err
+	}
+	if check.Timeout, err = parseDuration("timeout", s.Timeout); err != nil {
+		return nil, "", err
+	}
+	var password config.Secret
+	if ref := s.PasswordSecretRef; ref != nil {
+		password.SecretRef = &config.SecretKeyRef{Namespace: hc.Metadata.Namespace, Name: ref.Name, Key: ref.Key}
+	}
+
+	switch s.Type {
+	case TypeDatabase:
+		db, err := config.ParseDSN(s.Target)
+		if err != nil {
+			return nil, "", err
+		}
+		db.Name = name
+		if s.Username != "" {
+			db.Username = s.Username
+		}
+		if password.IsSet() {
+			db.Password = password
+		}
+		db.Check = check
+		return &config.RuntimeTargets{Databases: []config.DatabaseConfig{db}}, "database/" + name, nil
+	case TypeRegistry:
+		registry := config.RegistryConfig{
+			Name:     name,
+			URL:      s.Target,
+			Username: s.Username,
+			Password: password,
+			Insecure: s.Insecure,
+			Check:    check,
+		}
+		return &config.RuntimeTargets{Registries: []config.RegistryConfig{registry}}, "registry/" + name, nil
+	case config.ProbeTCP, config.ProbeHTTP, config.ProbeDNS, config.ProbeTLS:
+		probe := config.ProbeConfig{
+			Name:            name,
+			Type:            s.Type,
+			Target:          s.Target,
+			Method:          s.Method,
+			ExpectedStatus:  s.ExpectedStatus,
+			ExpectedBody:    s.ExpectedBody,
+			ExpectedHeaders: s.ExpectedHeaders,
+			RecordType:      s.RecordType,
+			DNSServer:       s.DNSServer,
+			ExpectedAnswers: s.ExpectedAnswers,
+			ServerName:      s.ServerName,
+			Insecure:        s.Insecure,
+			Check:           check,
+		}
+		return &config.RuntimeTargets{Probes: []config.ProbeConfig{probe}}, "probe/" + name, nil
+	default:
+		return nil, "", fmt.Errorf("type %q is invalid, expected one of %s", s.Type, strings.Join(types, ", "))
+	}
+}
+
+// parseDuration parses an optional duration field, zero if empty
+func parseDuration(field, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s %q is invalid: %w", field, value, err)
+	}
+	return d, nil
+}
+
+// configured reports whether cfg already has a target of the check name, which takes precedence
+func configured(cfg *config.Config, check string) bool {
+	kind, name, _ := strings.Cut(check, "/")
+	switch kind {
+	case "database":
+		return slices.ContainsFunc(cfg.Databases, func(db config.DatabaseConfig) bool { return db.Name == name })
+	case "registry":
+		return slices.ContainsFunc(cfg.Registries, func(r config.RegistryConfig) bool { return r.Name == name })
+	case "probe":
+		return slices.ContainsFunc(cfg.Probes, func(p config.ProbeConfig) bool { return p.Name == name })
+	default:
+		return false
+	}
+}
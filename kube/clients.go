@@ -0,0 +1,128 @@
+package kube
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/rainbond/health-console/config"
+)
+
+// userAgent identifies health-console in the API server audit log
+const userAgent = "rainbond-health-console"
+
+// Clients provides the Kubernetes clients shared by the collectors, the secret resolver and
+// discovery, so they all talk to the same cluster. Clients are created on first use
+type Clients struct {
+	description string
+	restConfig  *rest.Config
+	err         error // Why restConfig could not be loaded
+
+	mu        sync.Mutex
+	clientset kubernetes.Interface
+	dynamic   dynamic.Interface
+}
+
+// NewClients loads the client configuration selected by cfg
+// A configuration that cannot be loaded is not fatal, it is reported by every client request
+// so that checks not using the cluster keep working
+func NewClients(cfg *config.Config) *Clients {
+	c := &Clients{}
+	if cfg.InCluster {
+		c.description = "in-cluster"
+		c.restConfig, c.err = rest.InClusterConfig()
+		if c.err != nil {
+			c.err = fmt.Errorf("failed to create in-cluster config: %w", c.err)
+		}
+	} else {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		if cfg.Kubeconfig != "" {
+			rules.ExplicitPath = cfg.Kubeconfig
+		}
+		loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
+			CurrentContext: cfg.KubeContext,
+		})
+
+		c.description = describeKubeconfig(loader, rules, cfg.KubeContext)
+		c.restConfig, c.err = loader.ClientConfig()
+		if c.err != nil {
+			c.err = fmt.Errorf("failed to load kubeconfig: %w", c.err)
+		}
+	}
+
+	if c.restConfig != nil {
+		c.restConfig.QPS = cfg.KubeQPS
+		c.restConfig.Burst = cfg.KubeBurst
+		c.restConfig.UserAgent = userAgent
+	}
+	return c
+}
+
+// String describes where the client configuration comes from
+func (c *Clients) String() string {
+	return c.description
+}
+
+// Err returns why the client configuration could not be loaded, if it could not
+func (c *Clients) Err() error {
+	return c.err
+}
+
+// Kubernetes returns the typed clientset
+func (c *Clients) Kubernetes() (kubernetes.Interface, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.clientset == nil {
+		clientset, err := kubernetes.NewForConfig(c.restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create clientset: %w", err)
+		}
+		c.clientset = clientset
+	}
+	return c.clientset, nil
+}
+
+// Dynamic returns the dynamic client, used for custom resources
+func (c *Clients) Dynamic() (dynamic.Interface, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dynamic == nil {
+		client, err := dynamic.NewForConfig(c.restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+		}
+		c.dynamic = client
+	}
+	return c.dynamic, nil
+}
+
+// describeKubeconfig describes the kubeconfig file and context a client config loads
+func describeKubeconfig(loader clientcmd.ClientConfig, rules *clientcmd.ClientConfigLoadingRules, kubeContext string) string {
+	path := rules.ExplicitPath
+	if path == "" {
+		path = rules.GetDefaultFilename()
+	}
+
+	if kubeContext == "" {
+		raw, err := loader.RawConfig()
+		if err != nil {
+			return "kubeconfig " + path
+		}
+		kubeContext = raw.CurrentContext
+	}
+	return fmt.Sprintf("kubeconfig %s, context %q", path, kubeContext)
+}
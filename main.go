@@ -1,70 +1,133 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/rainbond/health-console/collectors"
 	"github.com/rainbond/health-console/config"
+	"github.com/rainbond/health-console/discovery"
+	"github.com/rainbond/health-console/healthcheck"
+	"github.com/rainbond/health-console/kube"
+	"github.com/rainbond/health-console/metrics"
+	"github.com/rainbond/health-console/targets"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+
 	log.Println("Starting Rainbond Health Console...")
 
 	// Load configuration
-	cfg := config.LoadConfig()
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	// Every component talks to the cluster through the same clients
+	clients := kube.NewClients(cfg)
+	discoverer := discovery.New(clients)
+
+	// Stops the watches of the configuration sources on shutdown
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+
+	// Runtime targets take precedence over HealthCheck targets and both over discovered ones,
+	// so they are applied in that order
+	var store *targets.Store
+	var runtime *targets.Snapshot
+	if cfg.TargetAPI.Enabled {
+		store = targets.NewStore(clients, cfg.TargetAPI)
+		runtime = loadRuntimeTargets(store, nil)
+		if err := runtime.Apply(cfg); err != nil {
+			log.Fatalf("Invalid runtime targets in ConfigMap %s: %v", store, err)
+		}
+	}
+	var healthChecks *healthcheck.Controller
+	if cfg.HealthChecks.Enabled {
+		healthChecks, err = healthcheck.NewController(clients, cfg.HealthChecks)
+		if err != nil {
+			log.Fatalf("Failed to create HealthCheck controller: %v", err)
+		}
+		if err := healthChecks.Start(watchCtx); err != nil {
+			log.Printf("Warning: Starting without HealthChecks: %v", err)
+		}
+		if err := healthChecks.Apply(cfg); err != nil {
+			log.Fatalf("Failed to apply HealthChecks: %v", err)
+		}
+	}
+	discovered := discover(discoverer, cfg, nil)
+
 	log.Printf("Configuration loaded:")
 	log.Printf("  - Metrics Port: %d", cfg.MetricsPort)
+	log.Printf("  - Collect Mode: %s", cfg.CollectMode)
 	log.Printf("  - Collect Interval: %s", cfg.CollectInterval)
+	log.Printf("  - Kubernetes: %s", clients)
 	log.Printf("  - Database Instances: %d", len(cfg.Databases))
 	log.Printf("  - Registry Instances: %d", len(cfg.Registries))
+	log.Printf("  - Probes: %d", len(cfg.Probes))
+	log.Printf("  - Plugins: %d", len(cfg.Plugins))
+	if cfg.Discovery.Enabled {
+		log.Printf("  - Discovered Targets: %d", discovered.Count())
+	}
+	if store != nil {
+		log.Printf("  - Target API: ConfigMap %s", store)
+	}
+	if healthChecks != nil {
+		log.Printf("  - HealthChecks: namespace %s", healthChecks)
+	}
 
 	// Initialize collectors
-	var collectorList []interface{ Stop() }
-
-	// Database collector
-	if len(cfg.Databases) > 0 {
-		dbCollector := collectors.NewDatabaseCollector(cfg)
-		dbCollector.Start()
-		collectorList = append(collectorList, dbCollector)
-	} else {
-		log.Println("No database instances configured, skipping database collector")
-	}
+	sink := collectors.NewSink()
+	scheduler := collectors.NewScheduler(sink, cfg)
+	secrets := collectors.NewSecretResolver(clients)
+	pools := collectors.NewDatabasePools()
 
-	// Kubernetes collector
-	k8sCollector, err := collectors.NewKubernetesCollector(cfg)
-	if err != nil {
-		log.Printf("Warning: Failed to initialize Kubernetes collector: %v", err)
-	} else {
-		k8sCollector.Start()
-		collectorList = append(collectorList, k8sCollector)
+	for _, name := range collectorNames {
+		c, err := newCollector(name, cfg, clients, secrets, pools)
+		if err != nil {
+			log.Printf("Warning: Failed to initialize %s collector: %v", name, err)
+			continue
+		}
+		if c != nil {
+			addCollector(scheduler, c)
+		}
 	}
 
-	// Registry collector
-	if len(cfg.Registries) > 0 {
-		registryCollector := collectors.NewRegistryCollector(cfg)
-		registryCollector.Start()
-		collectorList = append(collectorList, registryCollector)
-	} else {
-		log.Println("No registry instances configured, skipping registry collector")
+	if err := scheduler.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start collectors: %v", err)
 	}
 
-	// Storage (MinIO) collector
-	storageCollector := collectors.NewStorageCollector(cfg)
-	storageCollector.Start()
-	collectorList = append(collectorList, storageCollector)
+	// Reload the configuration on SIGHUP, when the config file changes, when the discovered
+	// targets change, when the runtime targets change and when HealthChecks change
+	reloader := newReloader(scheduler, clients, secrets, pools, discoverer, store, healthChecks, cfg, runtime, discovered)
 
 	// Setup HTTP server for metrics
-	http.Handle("/metrics", promhttp.Handler())
+	// The scheduler is gathered before the health metrics, so in scrape-driven mode
+	// the due checks have refreshed by the time their metrics are read
+	schedulerRegistry := prometheus.NewRegistry()
+	schedulerRegistry.MustRegister(scheduler)
+	gatherers := prometheus.Gatherers{schedulerRegistry, metrics.Registry, prometheus.DefaultGatherer}
+	http.Handle("/metrics", promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}))
 	http.HandleFunc("/health", healthHandler)
+	http.HandleFunc("/checks", checksHandler(scheduler))
+	http.HandleFunc("/results", resultsHandler(sink))
 	http.HandleFunc("/", indexHandler)
+	if store != nil {
+		api := &targetAPI{reloader: reloader, secrets: secrets, token: cfg.TargetAPI.Token}
+		api.register(http.DefaultServeMux)
+	}
 
 	// Start HTTP server in a goroutine
 	server := &http.Server{
@@ -82,26 +145,134 @@ func main() {
 		}
 	}()
 
+	if path := os.Getenv("CONFIG_FILE"); path != "" && cfg.ReloadInterval > 0 {
+		go reloader.watch(watchCtx, path, cfg.ReloadInterval)
+	}
+	if cfg.Discovery.Interval > 0 {
+		go reloader.watchDiscovery(watchCtx, cfg.Discovery.Interval)
+	}
+	if store != nil && cfg.ReloadInterval > 0 {
+		go reloader.watchTargets(watchCtx, cfg.ReloadInterval)
+	}
+	if healthChecks != nil {
+		go healthChecks.Run(watchCtx, sink, reloader.reload)
+	}
+
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reloader.reload("SIGHUP")
+	}
+	stopWatching()
 
 	log.Println("Shutting down...")
 
-	// Stop all collectors
-	for _, collector := range collectorList {
-		collector.Stop()
+	// Bound the whole shutdown so the pod terminates within its grace period
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Cancel in-flight checks and wait for them (and their cleanup) to finish
+	if err := scheduler.Stop(shutdownCtx); err != nil {
+		log.Printf("Error stopping collectors: %v", err)
 	}
 
-	// Shutdown HTTP server
-	if err := server.Close(); err != nil {
-		log.Printf("Error closing HTTP server: %v", err)
+	// Shutdown HTTP server, letting in-flight scrapes complete
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down HTTP server: %v", err)
 	}
 
 	log.Println("Shutdown complete")
 }
 
+// collectorNames lists the collectors in registration order
+var collectorNames = []string{"database", "kubernetes", "registry", "probe", "plugin", "storage"}
+
+// newCollector builds the named collector from cfg, or returns nil if it is not configured
+// The cluster is accessed through clients, the credentials of the targets are read through secrets
+// and the database connection pools are kept in pools
+func newCollector(name string, cfg *config.Config, clients *kube.Clients, secrets *collectors.SecretResolver,
+	pools *collectors.DatabasePools) (collectors.Collector, error) {
+	switch name {
+	case "database":
+		if len(cfg.Databases) == 0 {
+			log.Println("No database instances configured, skipping database collector")
+			return nil, nil
+		}
+		return collectors.NewDatabaseCollector(cfg, secrets, pools), nil
+	case "kubernetes":
+		c, err := collectors.NewKubernetesCollector(cfg, clients)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	case "registry":
+		if len(cfg.Registries) == 0 {
+			log.Println("No registry instances configured, skipping registry collector")
+			return nil, nil
+		}
+		return collectors.NewRegistryCollector(cfg, secrets), nil
+	case "probe":
+		if len(cfg.Probes) == 0 {
+			log.Println("No probes configured, skipping probe collector")
+			return nil, nil
+		}
+		return collectors.NewProbeCollector(cfg), nil
+	case "plugin":
+		if len(cfg.Plugins) == 0 {
+			log.Println("No plugins configured, skipping plugin collector")
+			return nil, nil
+		}
+		return collectors.NewPluginCollector(cfg), nil
+	case "storage":
+		if cfg.MinIO.Endpoint == "" {
+			log.Println("MinIO not configured, skipping storage collector")
+			return nil, nil
+		}
+		return collectors.NewStorageCollector(cfg, secrets), nil
+	default:
+		return nil, fmt.Errorf("unknown collector %q", name)
+	}
+}
+
+// addCollector registers a collector with the scheduler, logging instead of failing on conflicts
+func addCollector(scheduler *collectors.Scheduler, c collectors.Collector) {
+	if err := scheduler.Add(c); err != nil {
+		log.Printf("Warning: Failed to add %s collector: %v", c.Name(), err)
+	}
+}
+
+// checksHandler reports the scheduling state of every check
+// The source query parameter limits the report to manually configured or discovered targets
+func checksHandler(scheduler *collectors.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source := r.URL.Query().Get("source")
+		states := slices.DeleteFunc(scheduler.States(), func(state collectors.CheckState) bool {
+			return source != "" && state.Source != source
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(states)
+	}
+}
+
+// resultsHandler reports the latest result of every check and target
+// The source query parameter limits the report to manually configured or discovered targets
+func resultsHandler(sink *collectors.Sink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source := r.URL.Query().Get("source")
+		results := slices.DeleteFunc(sink.Results(), func(result collectors.CheckResult) bool {
+			return source != "" && result.Source != source
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
 // healthHandler handles health check requests
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
@@ -137,12 +308,29 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
         <div class="description">Health check endpoint</div>
     </div>
 
+    <div class="endpoint">
+        <a href="/checks">/checks</a>
+        <div class="description">Scheduling state of every check, <a href="/checks?source=discovered">discovered</a> or <a href="/checks?source=manual">manually configured</a> ones only</div>
+    </div>
+
+    <div class="endpoint">
+        <a href="/results">/results</a>
+        <div class="description">Latest result of every check and target, <a href="/results?source=discovered">discovered</a> or <a href="/results?source=manual">manually configured</a> ones only</div>
+    </div>
+
+    <div class="endpoint">
+        <a href="/api/targets">/api/targets</a>
+        <div class="description">Runtime target API (databases, registries, MinIO), if enabled; requires the bearer token</div>
+    </div>
+
     <h2>Monitored Components</h2>
     <ul>
         <li>Database connectivity (MySQL)</li>
         <li>Kubernetes cluster (API Server, CoreDNS, Etcd, Storage)</li>
         <li>Container registry</li>
         <li>Object storage (MinIO/S3)</li>
+        <li>Generic dependency probes (TCP, HTTP, DNS, TLS)</li>
+        <li>External check plugins</li>
     </ul>
 </body>
 </html>`
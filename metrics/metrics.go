@@ -5,10 +5,17 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// Registry holds every health metric, separate from the default registry's process and Go metrics,
+// so the HTTP handler controls the order in which they are gathered
+var Registry = prometheus.NewRegistry()
+
+// factory registers the health metrics with Registry
+var factory = promauto.With(Registry)
+
 // P0 - Critical infrastructure metrics
 
 // MySQLUp indicates if MySQL database is reachable (1=up, 0=down)
-var MySQLUp = promauto.NewGaugeVec(
+var MySQLUp = factory.NewGaugeVec(
 	prometheus.GaugeOpts{
 		Name: "mysql_up",
 		Help: "MySQL database availability (1=up, 0=down)",
@@ -16,8 +23,126 @@ var MySQLUp = promauto.NewGaugeVec(
 	[]string{"instance", "host", "port"},
 )
 
+// MySQLPoolStats exposes the connection pool statistics of a MySQL instance
+var MySQLPoolStats = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_pool_stats",
+		Help: "Connection pool statistics of a MySQL instance: open_connections, in_use, idle, wait_count, wait_duration_seconds",
+	},
+	[]string{"instance", "host", "port", "key"},
+)
+
+// MySQLThreadsConnected is the number of connections open on a MySQL instance
+var MySQLThreadsConnected = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_threads_connected",
+		Help: "Connections open on a MySQL instance (Threads_connected)",
+	},
+	[]string{"instance", "host", "port"},
+)
+
+// MySQLMaxConnections is the connection limit of a MySQL instance
+var MySQLMaxConnections = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_max_connections",
+		Help: "Maximum number of connections of a MySQL instance (max_connections)",
+	},
+	[]string{"instance", "host", "port"},
+)
+
+// MySQLUptimeSeconds is the time since a MySQL instance started
+var MySQLUptimeSeconds = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_uptime_seconds",
+		Help: "Time since a MySQL instance started (Uptime)",
+	},
+	[]string{"instance", "host", "port"},
+)
+
+// MySQLReadOnly indicates if a MySQL instance rejects writes, e.g. after a failover
+var MySQLReadOnly = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_read_only",
+		Help: "Whether a MySQL instance is read-only (read_only, 1=on, 0=off)",
+	},
+	[]string{"instance", "host", "port"},
+)
+
+// MySQLSuperReadOnly indicates if a MySQL instance rejects writes of SUPER users too
+var MySQLSuperReadOnly = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_super_read_only",
+		Help: "Whether a MySQL instance is read-only for SUPER users too (super_read_only, 1=on, 0=off)",
+	},
+	[]string{"instance", "host", "port"},
+)
+
+// MySQLReplicaIORunning indicates if a MySQL replica receives the binary log of its source
+var MySQLReplicaIORunning = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_replica_io_running",
+		Help: "Whether the replication IO thread of a MySQL replica is running on every channel (1=yes, 0=no)",
+	},
+	[]string{"instance", "host", "port"},
+)
+
+// MySQLReplicaSQLRunning indicates if a MySQL replica applies the received binary log
+var MySQLReplicaSQLRunning = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_replica_sql_running",
+		Help: "Whether the replication SQL thread of a MySQL replica is running on every channel (1=yes, 0=no)",
+	},
+	[]string{"instance", "host", "port"},
+)
+
+// MySQLReplicaSecondsBehindSource is how far a MySQL replica lags behind its source
+var MySQLReplicaSecondsBehindSource = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_replica_seconds_behind_source",
+		Help: "Replication lag of a MySQL replica, the largest of its channels (Seconds_Behind_Source)",
+	},
+	[]string{"instance", "host", "port"},
+)
+
+// MySQLHeartbeatWriteSeconds is how long the write probe of a MySQL instance took to upsert its heartbeat
+var MySQLHeartbeatWriteSeconds = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_heartbeat_write_seconds",
+		Help: "Time taken to upsert the heartbeat row of the MySQL write probe",
+	},
+	[]string{"instance", "host", "port"},
+)
+
+// MySQLHeartbeatReadSeconds is how long the write probe of a MySQL instance took to read its heartbeat back
+var MySQLHeartbeatReadSeconds = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_heartbeat_read_seconds",
+		Help: "Time taken to read back the heartbeat row of the MySQL write probe",
+	},
+	[]string{"instance", "host", "port"},
+)
+
+// MySQLSchemaMissingTables is the number of core Rainbond tables missing from a database with a role
+var MySQLSchemaMissingTables = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_schema_missing_tables",
+		Help: "Number of core Rainbond tables missing from the schema of a MySQL database with a console or region role",
+	},
+	[]string{"instance", "host", "port"},
+)
+
+// MySQLSchemaInfo is 1 for the Rainbond schema of a database with a role, labelled with its latest
+// applied migration
+var MySQLSchemaInfo = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "mysql_schema_info",
+		Help: "Rainbond schema of a MySQL database with a console or region role, version is its latest applied migration",
+	},
+	[]string{"instance", "host", "port", "role", "version"},
+)
+
 // KubernetesAPIServerUp indicates if Kubernetes API Server is reachable
-var KubernetesAPIServerUp = promauto.NewGaugeVec(
+var KubernetesAPIServerUp = factory.NewGaugeVec(
 	prometheus.GaugeOpts{
 		Name: "kubernetes_apiserver_up",
 		Help: "Kubernetes API Server availability (1=up, 0=down)",
@@ -26,7 +151,7 @@ var KubernetesAPIServerUp = promauto.NewGaugeVec(
 )
 
 // CoreDNSUp indicates if CoreDNS is working properly
-var CoreDNSUp = promauto.NewGaugeVec(
+var CoreDNSUp = factory.NewGaugeVec(
 	prometheus.GaugeOpts{
 		Name: "coredns_up",
 		Help: "CoreDNS availability (1=up, 0=down)",
@@ -35,7 +160,7 @@ var CoreDNSUp = promauto.NewGaugeVec(
 )
 
 // EtcdUp indicates if Etcd cluster is available
-var EtcdUp = promauto.NewGaugeVec(
+var EtcdUp = factory.NewGaugeVec(
 	prometheus.GaugeOpts{
 		Name: "etcd_up",
 		Help: "Etcd cluster availability (1=up, 0=down)",
@@ -44,7 +169,7 @@ var EtcdUp = promauto.NewGaugeVec(
 )
 
 // ClusterStorageUp indicates if cluster storage is available
-var ClusterStorageUp = promauto.NewGaugeVec(
+var ClusterStorageUp = factory.NewGaugeVec(
 	prometheus.GaugeOpts{
 		Name: "cluster_storage_up",
 		Help: "Cluster storage class availability (1=up, 0=down)",
@@ -53,7 +178,7 @@ var ClusterStorageUp = promauto.NewGaugeVec(
 )
 
 // RegistryUp indicates if container registry is reachable
-var RegistryUp = promauto.NewGaugeVec(
+var RegistryUp = factory.NewGaugeVec(
 	prometheus.GaugeOpts{
 		Name: "registry_up",
 		Help: "Container registry availability (1=up, 0=down)",
@@ -62,7 +187,7 @@ var RegistryUp = promauto.NewGaugeVec(
 )
 
 // MinIOUp indicates if MinIO/S3 is reachable
-var MinIOUp = promauto.NewGaugeVec(
+var MinIOUp = factory.NewGaugeVec(
 	prometheus.GaugeOpts{
 		Name: "minio_up",
 		Help: "MinIO/S3 availability (1=up, 0=down)",
@@ -70,8 +195,35 @@ var MinIOUp = promauto.NewGaugeVec(
 	[]string{},
 )
 
+// ProbeUp indicates if a generic blackbox probe succeeded
+var ProbeUp = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "probe_up",
+		Help: "Generic dependency probe result (1=up, 0=down)",
+	},
+	[]string{"instance", "type", "target"},
+)
+
+// PluginUp indicates if an external check plugin reported its target as healthy
+var PluginUp = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "plugin_up",
+		Help: "External check plugin result (1=up, 0=down)",
+	},
+	[]string{"instance"},
+)
+
+// PluginValue exposes the extra numeric values reported by an external check plugin
+var PluginValue = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "plugin_value",
+		Help: "Numeric value reported by an external check plugin",
+	},
+	[]string{"instance", "key"},
+)
+
 // HealthCheckErrors tracks errors during health checks
-var HealthCheckErrors = promauto.NewCounterVec(
+var HealthCheckErrors = factory.NewCounterVec(
 	prometheus.CounterOpts{
 		Name: "health_check_errors_total",
 		Help: "Total number of health check errors",
@@ -80,7 +232,7 @@ var HealthCheckErrors = promauto.NewCounterVec(
 )
 
 // HealthCheckDuration tracks duration of health checks
-var HealthCheckDuration = promauto.NewHistogramVec(
+var HealthCheckDuration = factory.NewHistogramVec(
 	prometheus.HistogramOpts{
 		Name:    "health_check_duration_seconds",
 		Help:    "Duration of health checks in seconds",
@@ -88,3 +240,73 @@ var HealthCheckDuration = promauto.NewHistogramVec(
 	},
 	[]string{"collector"},
 )
+
+// HealthCheckSkipped counts scheduled runs skipped because the previous run was still in flight
+var HealthCheckSkipped = factory.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "health_check_skipped_total",
+		Help: "Total number of scheduled health check runs skipped because the previous run was still in progress",
+	},
+	[]string{"check"},
+)
+
+// HealthCheckOverruns counts runs that took longer than the check interval
+var HealthCheckOverruns = factory.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "health_check_overruns_total",
+		Help: "Total number of health check runs that took longer than their interval",
+	},
+	[]string{"check"},
+)
+
+// HealthCheckBlocked marks checks reported as unknown because a dependency is not up
+var HealthCheckBlocked = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "health_check_blocked",
+		Help: "Whether a health check is blocked by an unhealthy dependency (1 = blocked)",
+	},
+	[]string{"check", "dependency"},
+)
+
+// HealthCheckFailureReason exposes the classified reason of a currently failing check target
+var HealthCheckFailureReason = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "health_check_failure_reason",
+		Help: "Classified reason of a failing health check target (1 while failing with this reason)",
+	},
+	[]string{"collector", "target", "reason"},
+)
+
+// HealthCheckLastSeen records when a check target last produced a result
+var HealthCheckLastSeen = factory.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "health_check_last_seen_timestamp_seconds",
+		Help: "Unix timestamp of the last result produced for a health check target",
+	},
+	[]string{"collector", "target"},
+)
+
+// ConfigReloads counts configuration reloads by result ("success" or "failure")
+var ConfigReloads = factory.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "health_console_config_reloads_total",
+		Help: "Total number of configuration reloads by result",
+	},
+	[]string{"result"},
+)
+
+// ConfigLastReloadSuccessful reports whether the last configuration reload succeeded
+var ConfigLastReloadSuccessful = factory.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "health_console_config_last_reload_successful",
+		Help: "Whether the last configuration reload succeeded (1 = success, 0 = failure)",
+	},
+)
+
+// ConfigLastReloadSuccessTimestamp records when the configuration was last loaded successfully
+var ConfigLastReloadSuccessTimestamp = factory.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "health_console_config_last_reload_success_timestamp_seconds",
+		Help: "Unix timestamp of the last successful configuration load",
+	},
+)
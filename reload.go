@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/rainbond/health-console/collectors"
+	"github.com/rainbond/health-console/config"
+	"github.com/rainbond/health-console/discovery"
+	"github.com/rainbond/health-console/healthcheck"
+	"github.com/rainbond/health-console/kube"
+	"github.com/rainbond/health-console/metrics"
+	"github.com/rainbond/health-console/targets"
+)
+
+// discoveryTimeout bounds a single discovery run
+const discoveryTimeout = 30 * time.Second
+
+// storeTimeout bounds reading or writing the runtime targets
+const storeTimeout = 10 * time.Second
+
+// reloader applies configuration changes to the running scheduler
+// Only the collectors whose configuration changed are rebuilt
+type reloader struct {
+	scheduler    *collectors.Scheduler
+	clients      *kube.Clients
+	secrets      *collectors.SecretResolver
+	pools        *collectors.DatabasePools
+	discoverer   *discovery.Discoverer
+	store        *targets.Store          // nil if the target API is disabled
+	healthChecks *healthcheck.Controller // nil if HealthChecks are disabled
+
+	mu         sync.Mutex
+	cfg        *config.Config     // Configuration currently applied
+	runtime    *targets.Snapshot  // Runtime targets currently applied
+	discovered *discovery.Targets // Discovered targets currently applied
+
+	// stale is the configuration of the collectors that failed to reload, by collector name. They
+	// keep running with it, so the next reload compares against it rather than cfg
+	stale map[string]*config.Config
+}
+
+// newReloader creates a reloader for the configuration the scheduler was started with
+func newReloader(scheduler *collectors.Scheduler, clients *kube.Clients, secrets *collectors.SecretResolver,
+	pools *collectors.DatabasePools, discoverer *discovery.Discoverer, store *targets.Store,
+	healthChecks *healthcheck.Controller, cfg *config.Config, runtime *targets.Snapshot,
+	discovered *discovery.Targets) *reloader {
+	metrics.ConfigLastReloadSuccessful.Set(1)
+	metrics.ConfigLastReloadSuccessTimestamp.SetToCurrentTime()
+
+	return &reloader{
+		scheduler:    scheduler,
+		clients:      clients,
+		secrets:      secrets,
+		pools:        pools,
+		discoverer:   discoverer,
+		store:        store,
+		healthChecks: healthChecks,
+		cfg:          cfg,
+		stale:        make(map[string]*config.Config),
+		runtime:      runtime,
+		discovered:   discovered,
+	}
+}
+
+// reload loads the configuration again and rebuilds the collectors whose configuration changed
+func (r *reloader) reload(trigger string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log.Printf("Reloading configuration (%s)...", trigger)
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		r.failed(err)
+		return
+	}
+	runtime := loadRuntimeTargets(r.store, r.runtime)
+	if err := runtime.Apply(cfg); err != nil {
+		r.failed(fmt.Errorf("invalid runtime targets in ConfigMap %s: %w", r.store, err))
+		return
+	}
+	if err := r.healthChecks.Apply(cfg); err != nil {
+		r.failed(err)
+		return
+	}
+	discovered := discover(r.discoverer, cfg, r.discovered)
+
+	changed, err := r.apply(cfg)
+	if err != nil {
+		r.failed(err)
+		return
+	}
+
+	r.runtime = runtime
+	r.discovered = discovered
+	metrics.ConfigReloads.WithLabelValues("success").Inc()
+	metrics.ConfigLastReloadSuccessful.Set(1)
+	metrics.ConfigLastReloadSuccessTimestamp.SetToCurrentTime()
+	if changed == 0 {
+		log.Println("Configuration reloaded, no collector changed")
+	} else {
+		log.Printf("Configuration reloaded, %d collector(s) changed", changed)
+	}
+}
+
+// apply rebuilds the collectors whose configuration differs between the configuration they run
+// with and cfg, then makes cfg the applied configuration. It returns the number of reloaded
+// collectors. A collector that fails to reload keeps running with its previous configuration and
+// is reloaded again by the next apply; the others are reloaded regardless
+// The caller holds r.mu
+func (r *reloader) apply(cfg *config.Config) (int, error) {
+	warnRestartRequired(r.cfg, cfg)
+
+	// Build every changed collector first, so a bad configuration leaves the running ones untouched
+	changed := make(map[string]collectors.Collector)
+	for _, name := range collectorNames {
+		if reflect.DeepEqual(collectorConfig(name, r.running(name)), collectorConfig(name, cfg)) {
+			continue
+		}
+		c, err := newCollector(name, cfg, r.clients, r.secrets, r.pools)
+		if err != nil {
+			return 0, fmt.Errorf("failed to initialize %s collector: %w", name, err)
+		}
+		changed[name] = c
+	}
+
+	var errs []error
+	stale := make(map[string]*config.Config)
+	for _, name := range collectorNames {
+		c, ok := changed[name]
+		if !ok {
+			continue
+		}
+		if err := r.scheduler.Reload(name, c); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reload %s collector: %w", name, err))
+			stale[name] = r.running(name)
+			continue
+		}
+		if c == nil {
+			log.Printf("Removed %s collector", name)
+		} else {
+			log.Printf("Reloaded %s collector", name)
+		}
+	}
+
+	// The unchanged collectors run with their part of cfg already
+	r.cfg = cfg
+	r.stale = stale
+	return len(changed) - len(stale), errors.Join(errs...)
+}
+
+// running returns the configuration the named collector runs with
+// The caller holds r.mu
+func (r *reloader) running(name string) *config.Config {
+	if cfg, ok := r.stale[name]; ok {
+		return cfg
+	}
+	return r.cfg
+}
+
+// updateTargets changes the runtime targets with update, persists them and applies them
+// update is given the configuration without runtime, HealthCheck and discovered targets, so it can refuse
+// targets that are configured. The configuration is loaded again, so changes of the config file
+// not reloaded yet are applied as well
+func (r *reloader) updateTargets(ctx context.Context, update func(*config.RuntimeTargets, *config.Config) error) (*targets.Snapshot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	current, err := r.store.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	next := current.Targets.Clone()
+	if err := update(next, cfg); err != nil {
+		return nil, err
+	}
+	// Validate before persisting, Apply leaves the copy unchanged on failure
+	check := *cfg
+	if err := next.Apply(&check, config.SourceAPI); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidTarget, err)
+	}
+
+	saved, err := r.store.Save(ctx, next, current)
+	if err != nil {
+		return nil, err
+	}
+	// The saved targets reference the moved credentials, exactly as a later reload reads them
+	if err := saved.Apply(cfg); err != nil {
+		return nil, err
+	}
+	if err := r.healthChecks.Apply(cfg); err != nil {
+		return nil, err
+	}
+	r.discovered.Apply(cfg)
+
+	changed, err := r.apply(cfg)
+	if err != nil {
+		r.failed(err)
+		return nil, fmt.Errorf("targets were saved but could not be applied: %w", err)
+	}
+	r.runtime = saved
+	log.Printf("Runtime targets updated, %d collector(s) changed", changed)
+	return saved, nil
+}
+
+// failed records a failed reload; the previous configuration stays in effect
+func (r *reloader) failed(err error) {
+	log.Printf("Error reloading configuration, keeping the previous one: %v", err)
+	metrics.ConfigReloads.WithLabelValues("failure").Inc()
+	metrics.ConfigLastReloadSuccessful.Set(0)
+}
+
+// watch polls the config file and reloads when its content changes, until ctx is cancelled
+// Content is compared rather than modification times, since mounted ConfigMaps are updated by
+// swapping a symlink
+func (r *reloader) watch(ctx context.Context, path string, interval time.Duration) {
+	last, _ := fileChecksum(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sum, err := fileChecksum(path)
+			if err != nil {
+				log.Printf("Error reading config file %s: %v", path, err)
+				continue
+			}
+			if sum != last {
+				last = sum
+				r.reload("config file changed")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watchDiscovery runs discovery periodically and reloads when the discovered targets change,
+// until ctx is cancelled
+func (r *reloader) watchDiscovery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			cfg, last := r.cfg, r.discovered
+			r.mu.Unlock()
+			if !cfg.Discovery.Enabled {
+				continue
+			}
+
+			targets, err := discoverTargets(r.discoverer, cfg)
+			if err != nil {
+				log.Printf("Warning: Discovery failed: %v", err)
+				continue
+			}
+			if !reflect.DeepEqual(targets, last) {
+				r.reload("discovered targets changed")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watchTargets polls the ConfigMap of the runtime targets and reloads when it was changed by
+// someone else, such as another replica or kubectl edit, until ctx is cancelled
+func (r *reloader) watchTargets(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			last := r.runtime
+			r.mu.Unlock()
+
+			snapshot := loadRuntimeTargets(r.store, last)
+			if snapshot != nil && (last == nil || snapshot.Version != last.Version) {
+				r.reload("runtime targets changed")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// discover adds the targets discovered from the cluster to cfg and returns them, if discovery is
+// enabled. If discovery fails the last discovered targets are used, so an unreachable API server
+// does not remove their checks
+func discover(discoverer *discovery.Discoverer, cfg *config.Config, last *discovery.Targets) *discovery.Targets {
+	if !cfg.Discovery.Enabled {
+		return nil
+	}
+
+	targets, err := discoverTargets(discoverer, cfg)
+	if err != nil {
+		log.Printf("Warning: Discovery failed, keeping %d previously discovered target(s): %v", last.Count(), err)
+		targets = last
+	}
+	targets.Apply(cfg)
+	return targets
+}
+
+// discoverTargets runs discovery bounded by discoveryTimeout
+func discoverTargets(discoverer *discovery.Discoverer, cfg *config.Config) (*discovery.Targets, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	return discoverer.Discover(ctx, cfg)
+}
+
+// loadRuntimeTargets reads the runtime targets, if the target API is enabled
+// If they cannot be read the last ones are used, so an unreachable API server does not remove
+// their checks
+func loadRuntimeTargets(store *targets.Store, last *targets.Snapshot) *targets.Snapshot {
+	if store == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	snapshot, err := store.Load(ctx)
+	if err != nil {
+		log.Printf("Warning: Failed to read runtime targets, keeping the previous ones: %v", err)
+		return last
+	}
+	return snapshot
+}
+
+// fileChecksum returns the SHA-256 of a file's content
+func fileChecksum(path string) ([sha256.Size]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+// collectorConfig returns the part of the configuration the named collector is built from
+func collectorConfig(name string, cfg *config.Config) any {
+	switch name {
+	case "database":
+		return []any{cfg.Databases, cfg.DatabasePool}
+	case "kubernetes":
+		return cfg.Kubernetes
+	case "registry":
+		return cfg.Registries
+	case "probe":
+		return cfg.Probes
+	case "plugin":
+		return []any{cfg.Plugins, cfg.PluginMaxConcurrency, cfg.PluginMaxOutputBytes}
+	case "storage":
+		return cfg.MinIO
+	default:
+		return nil
+	}
+}
+
+// warnRestartRequired logs the changed settings that only take effect after a restart
+func warnRestartRequired(old, cfg *config.Config) {
+	settings := []struct {
+		name     string
+		old, new any
+	}{
+		{"METRICS_PORT", old.MetricsPort, cfg.MetricsPort},
+		{"COLLECT_MODE", old.CollectMode, cfg.CollectMode},
+		{"SCRAPE_TIMEOUT", old.ScrapeTimeout, cfg.ScrapeTimeout},
+		{"SHUTDOWN_TIMEOUT", old.ShutdownTimeout, cfg.ShutdownTimeout},
+		{"RELOAD_INTERVAL", old.ReloadInterval, cfg.ReloadInterval},
+		{"DISCOVERY_INTERVAL", old.Discovery.Interval, cfg.Discovery.Interval},
+		{"IN_CLUSTER", old.InCluster, cfg.InCluster},
+		{"KUBE_CONFIG", old.Kubeconfig, cfg.Kubeconfig},
+		{"KUBE_CONTEXT", old.KubeContext, cfg.KubeContext},
+		{"KUBE_QPS", old.KubeQPS, cfg.KubeQPS},
+		{"KUBE_BURST", old.KubeBurst, cfg.KubeBurst},
+		{"TARGET_API_ENABLED", old.TargetAPI.Enabled, cfg.TargetAPI.Enabled},
+		{"TARGET_API_NAMESPACE", old.TargetAPI.Namespace, cfg.TargetAPI.Namespace},
+		{"TARGET_API_CONFIGMAP", old.TargetAPI.ConfigMap, cfg.TargetAPI.ConfigMap},
+		{"HEALTHCHECK_ENABLED", old.HealthChecks.Enabled, cfg.HealthChecks.Enabled},
+		{"HEALTHCHECK_NAMESPACE", old.HealthChecks.Namespace, cfg.HealthChecks.Namespace},
+	}
+	for _, s := range settings {
+		if s.old != s.new {
+			log.Printf("Warning: %s changed from %v to %v, restart to apply it", s.name, s.old, s.new)
+		}
+	}
+}
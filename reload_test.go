@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rainbond/health-console/collectors"
+	"github.com/rainbond/health-console/config"
+)
+
+// scheduledChecks returns the names of the checks of the scheduler
+func scheduledChecks(s *collectors.Scheduler) []string {
+	var names []string
+	for _, state := range s.States() {
+		names = append(names, state.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestApplyKeepsConfigOfFailedCollectors(t *testing.T) {
+	scheduler := collectors.NewScheduler(collectors.NewSink(), &config.Config{CollectMode: config.ModeBackground})
+	if err := scheduler.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = scheduler.Stop(context.Background()) })
+
+	check := config.CheckConfig{Interval: time.Hour, Timeout: time.Second}
+	cfg := &config.Config{PluginMaxConcurrency: 1, PluginMaxOutputBytes: 1024}
+	r := newReloader(scheduler, nil, nil, nil, nil, nil, nil, cfg, nil, nil)
+
+	// The plugin has no interval, which the scheduler refuses
+	next := *cfg
+	next.Probes = []config.ProbeConfig{{Name: "web", Type: config.ProbeTCP, Target: "127.0.0.1:1", Check: check}}
+	next.Plugins = []config.PluginConfig{{Name: "broken", Command: "true"}}
+	changed, err := r.apply(&next)
+	if err == nil {
+		t.Fatal("apply() succeeded with a collector the scheduler refuses")
+	}
+	if changed != 1 {
+		t.Errorf("apply() reloaded %d collectors, want the probe collector only", changed)
+	}
+	if names := scheduledChecks(scheduler); !slices.Equal(names, []string{"probe/web"}) {
+		t.Errorf("checks = %v, want [probe/web]", names)
+	}
+
+	// The failed collector is retried, the reloaded one is left alone
+	fixed := next
+	fixed.Plugins = []config.PluginConfig{{Name: "fixed", Command: "true", Check: check}}
+	changed, err = r.apply(&fixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 1 {
+		t.Errorf("apply() reloaded %d collectors, want the plugin collector only", changed)
+	}
+	if names := scheduledChecks(scheduler); !slices.Equal(names, []string{"plugin/fixed", "probe/web"}) {
+		t.Errorf("checks = %v, want [plugin/fixed probe/web]", names)
+	}
+
+	// Going back to the refused plugin is attempted again, not skipped as already applied
+	if _, err := r.apply(&next); err == nil {
+		t.Error("apply() succeeded with a collector the scheduler refuses")
+	}
+}
@@ -0,0 +1,279 @@
+package targets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/rainbond/health-console/config"
+	"github.com/rainbond/health-console/kube"
+)
+
+// dataKey is the ConfigMap key holding the targets
+const dataKey = "targets.yaml"
+
+// storeLabels mark the ConfigMap and the Secret written by the store
+var storeLabels = map[string]string{"app.kubernetes.io/managed-by": "rainbond-health-console"}
+
+// invalidKeyChars matches the characters Secret keys may not contain
+var invalidKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// Snapshot is the runtime targets read from or written to the ConfigMap at a resource version
+type Snapshot struct {
+	Targets *config.RuntimeTargets
+	Version string // Resource version of the ConfigMap, empty if it does not exist
+}
+
+// Apply adds the runtime targets to cfg, see config.RuntimeTargets.Apply
+func (s *Snapshot) Apply(cfg *config.Config) error {
+	if s == nil {
+		return nil
+	}
+	return s.Targets.Apply(cfg, config.SourceAPI)
+}
+
+// Store persists the runtime targets in a ConfigMap
+// Inline credentials are moved to the Secret of the same name and referenced from the targets,
+// so the ConfigMap never contains credentials
+type Store struct {
+	clients   *kube.Clients
+	namespace string
+	name      string
+}
+
+// NewStore creates a store for the ConfigMap configured in cfg
+func NewStore(clients *kube.Clients, cfg config.TargetAPIConfig) *Store {
+	return &Store{clients: clients, namespace: cfg.Namespace, name: cfg.ConfigMap}
+}
+
+// String returns the ConfigMap as namespace/name
+func (s *Store) String() string {
+	return s.namespace + "/" + s.name
+}
+
+// Load reads the persisted targets, a missing ConfigMap holds no targets
+func (s *Store) Load(ctx context.Context) (*Snapshot, error) {
+	clientset, err := s.clients.Kubernetes()
+	if err != nil {
+		return nil, err
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return &Snapshot{Targets: &config.RuntimeTargets{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ConfigMap %s: %w", s, err)
+	}
+
+	targets, err := config.ParseRuntimeTargets([]byte(cm.Data[dataKey]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s of ConfigMap %s: %w", dataKey, s, err)
+	}
+	return &Snapshot{Targets: targets, Version: cm.ResourceVersion}, nil
+}
+
+// Save persists targets over the snapshot last read and returns the new snapshot
+// It fails with a conflict error if the ConfigMap changed since last was read.
+// New credentials are written before the ConfigMap and unused ones removed after it, so the
+// persisted targets never reference a missing credential
+func (s *Store) Save(ctx context.Context, targets *config.RuntimeTargets, last *Snapshot) (*Snapshot, error) {
+	clientset, err := s.clients.Kubernetes()
+	if err != nil {
+		return nil, err
+	}
+
+	persisted, credentials := s.extractCredentials(targets)
+	current, err := s.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	merged := maps.Clone(current)
+	if merged == nil {
+		merged = make(map[string][]byte)
+	}
+	maps.Copy(merged, credentials)
+	if !maps.EqualFunc(merged, current, bytes.Equal) {
+		if err := s.writeCredentials(ctx, merged); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := persisted.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode targets: %w", err)
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            s.name,
+			Namespace:       s.namespace,
+			Labels:          storeLabels,
+			ResourceVersion: last.Version,
+		},
+		Data: map[string]string{dataKey: string(data)},
+	}
+	if last.Version == "" {
+		cm, err = clientset.CoreV1().ConfigMaps(s.namespace).Create(ctx, cm, metav1.CreateOptions{})
+	} else {
+		cm, err = clientset.CoreV1().ConfigMaps(s.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save ConfigMap %s: %w", s, err)
+	}
+
+	// The targets are saved, a credential left behind is only untidy
+	used := s.referencedKeys(persisted)
+	before := len(merged)
+	maps.DeleteFunc(merged, func(key string, _ []byte) bool { return !used[key] })
+	if len(merged) != before {
+		if err := s.writeCredentials(ctx, merged); err != nil {
+			log.Printf("Warning: Failed to remove unused credentials from Secret %s: %v", s, err)
+		}
+	}
+	return &Snapshot{Targets: persisted, Version: cm.ResourceVersion}, nil
+}
+
+// extractCredentials returns a copy of targets whose inline credentials reference the Secret of the
+// store instead, and the credentials to write to it
+func (s *Store) extractCredentials(targets *config.RuntimeTargets) (*config.RuntimeTargets, map[string][]byte) {
+	persisted := targets.Clone()
+	credentials := make(map[string][]byte)
+	extract := func(secret *config.Secret, kind, name, field string) {
+		if secret.Value == "" {
+			return
+		}
+		key := credentialKey(kind, name, field)
+		credentials[key] = []byte(secret.Value)
+		*secret = config.Secret{SecretRef: &config.SecretKeyRef{Namespace: s.namespace, Name: s.name, Key: key}}
+	}
+
+	for i := range persisted.Databases {
+		db := &persisted.Databases[i]
+		extract(&db.Password, "database", db.Name, "password")
+		extract(&db.TLS.Key, "database", db.Name, "tlsKey")
+	}
+	for i := range persisted.Registries {
+		registry := &persisted.Registries[i]
+		extract(&registry.Password, "registry", registry.Name, "password")
+	}
+	if minio := persisted.MinIO; minio != nil {
+		extract(&minio.AccessKey, "minio", "minio", "accessKey")
+		extract(&minio.SecretKey, "minio", "minio", "secretKey")
+	}
+	return persisted, credentials
+}
+
+// CheckCredentials refuses credentials of targets read from a file or from a Secret other than the
+// store's. Targets of the API may only carry inline credentials, which Save moves to the Secret of
+// the store, or references to it: the checks send the credentials to hosts the caller chooses, so
+// anything else would let the caller read any file or Secret the console can
+func (s *Store) CheckCredentials(targets *config.RuntimeTargets) error {
+	var errs []error
+	check := func(secret config.Secret, what string) {
+		switch {
+		case secret.File != "":
+			errs = append(errs, fmt.Errorf("%s: credentials cannot be read from a file", what))
+		case secret.SecretRef != nil && (secret.SecretRef.Namespace != s.namespace || secret.SecretRef.Name != s.name):
+			errs = append(errs, fmt.Errorf("%s: credentials can only reference the Secret %s, got %s", what, s, secret.SecretRef))
+		}
+	}
+
+	for _, db := range targets.Databases {
+		what := fmt.Sprintf("database %q", db.Name)
+		check(db.Password, what+" password")
+		check(db.TLS.CA, what+" tls ca")
+		check(db.TLS.Cert, what+" tls cert")
+		check(db.TLS.Key, what+" tls key")
+	}
+	for _, registry := range targets.Registries {
+		check(registry.Password, fmt.Sprintf("registry %q password", registry.Name))
+	}
+	if minio := targets.MinIO; minio != nil {
+		check(minio.AccessKey, "minio access key")
+		check(minio.SecretKey, "minio secret key")
+	}
+	return errors.Join(errs...)
+}
+
+// referencedKeys returns the keys of the Secret of the store that targets reference
+func (s *Store) referencedKeys(targets *config.RuntimeTargets) map[string]bool {
+	keys := make(map[string]bool)
+	add := func(secret config.Secret) {
+		if ref := secret.SecretRef; ref != nil && ref.Namespace == s.namespace && ref.Name == s.name {
+			keys[ref.Key] = true
+		}
+	}
+
+	for _, db := range targets.Databases {
+		add(db.Password)
+		add(db.TLS.Key)
+	}
+	for _, registry := range targets.Registries {
+		add(registry.Password)
+	}
+	if minio := targets.MinIO; minio != nil {
+		add(minio.AccessKey)
+		add(minio.SecretKey)
+	}
+	return keys
+}
+
+// credentials returns the data of the Secret of the store, nil if it does not exist
+func (s *Store) credentials(ctx context.Context) (map[string][]byte, error) {
+	clientset, err := s.clients.Kubernetes()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := clientset.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Secret %s: %w", s, err)
+	}
+	return secret.Data, nil
+}
+
+// writeCredentials replaces the data of the Secret of the store, creating it if needed
+func (s *Store) writeCredentials(ctx context.Context, data map[string][]byte) error {
+	clientset, err := s.clients.Kubernetes()
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace, Labels: storeLabels},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       data,
+	}
+	_, err = clientset.CoreV1().Secrets(s.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = clientset.CoreV1().Secrets(s.namespace).Create(ctx, secret, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save Secret %s: %w", s, err)
+	}
+	return nil
+}
+
+// credentialKey returns the Secret key of a credential of a target
+// Names with characters Secret keys do not allow are replaced and suffixed with a hash of the
+// name, so two targets cannot share a key
+func credentialKey(kind, name, field string) string {
+	safe := invalidKeyChars.ReplaceAllString(name, "-")
+	if safe != name {
+		sum := sha256.Sum256([]byte(name))
+		safe += "-" + hex.EncodeToString(sum[:4])
+	}
+	return kind + "." + safe + "." + field
+}
@@ -0,0 +1,73 @@
+package targets
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rainbond/health-console/config"
+)
+
+func TestCheckCredentials(t *testing.T) {
+	s := &Store{namespace: "rbd-system", name: "health-console-targets"}
+	own := func(key string) config.Secret {
+		return config.Secret{SecretRef: &config.SecretKeyRef{Namespace: "rbd-system", Name: "health-console-targets", Key: key}}
+	}
+
+	tests := []struct {
+		name    string
+		targets config.RuntimeTargets
+		wantErr []string
+	}{
+		{
+			name: "inline and own secret",
+			targets: config.RuntimeTargets{
+				Databases:  []config.DatabaseConfig{{Name: "db", Password: config.Secret{Value: "p"}, TLS: config.DatabaseTLSConfig{CA: config.Secret{Value: "pem"}, Key: own("database.db.tlsKey")}}},
+				Registries: []config.RegistryConfig{{Name: "harbor", Password: own("registry.harbor.password")}},
+				MinIO:      &config.MinIOConfig{AccessKey: config.Secret{Value: "a"}, SecretKey: config.Secret{Value: "s"}},
+			},
+		},
+		{
+			name: "file",
+			targets: config.RuntimeTargets{
+				Databases: []config.DatabaseConfig{{Name: "db", Password: config.Secret{File: "/var/run/secrets/kubernetes.io/serviceaccount/token"}}},
+			},
+			wantErr: []string{`database "db" password: credentials cannot be read from a file`},
+		},
+		{
+			name: "other secrets",
+			targets: config.RuntimeTargets{
+				Databases:  []config.DatabaseConfig{{Name: "db", TLS: config.DatabaseTLSConfig{Cert: config.Secret{SecretRef: &config.SecretKeyRef{Namespace: "kube-system", Name: "health-console-targets", Key: "k"}}}}},
+				Registries: []config.RegistryConfig{{Name: "harbor", Password: config.Secret{SecretRef: &config.SecretKeyRef{Namespace: "rbd-system", Name: "rbd-db", Key: "password"}}}},
+				MinIO:      &config.MinIOConfig{SecretKey: config.Secret{File: "/etc/shadow"}},
+			},
+			wantErr: []string{
+				`database "db" tls cert: credentials can only reference the Secret rbd-system/health-console-targets, got kube-system/health-console-targets/k`,
+				`registry "harbor" password: credentials can only reference the Secret rbd-system/health-console-targets, got rbd-system/rbd-db/password`,
+				"minio secret key: credentials cannot be read from a file",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.CheckCredentials(&tt.targets)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("CheckCredentials() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("CheckCredentials() = nil, want an error")
+			}
+			if got := strings.Split(err.Error(), "\n"); len(got) != len(tt.wantErr) {
+				t.Errorf("CheckCredentials() = %q, want %d problems", err, len(tt.wantErr))
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("CheckCredentials() = %q, want containing %q", err, want)
+				}
+			}
+		})
+	}
+}
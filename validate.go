@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/rainbond/health-console/config"
+)
+
+// envName matches keys that are usable as environment variable names
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// manifestObject is the part of a Kubernetes object that can carry configuration
+type manifestObject struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Data       map[string]string `json:"data"`
+	StringData map[string]string `json:"stringData"`
+}
+
+// manifestFlag collects the repeated -manifest flag
+type manifestFlag []string
+
+func (m *manifestFlag) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *manifestFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+// runValidate implements "health-console validate": it loads the configuration exactly like the
+// service does and reports every problem, returning a non-zero exit code if there is any
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	configFile := flags.String("config", "", "config file to validate (YAML or JSON), defaults to CONFIG_FILE")
+	var manifests manifestFlag
+	flags.Var(&manifests, "manifest", "Kubernetes manifest whose ConfigMaps and Secrets provide the environment (repeatable)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: health-console validate [-config file] [-manifest file]...")
+		fmt.Fprintln(os.Stderr, "Validates the configuration from the environment, the config file and the given manifests.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	files := make(map[string]string)
+	for _, path := range manifests {
+		if err := loadManifest(path, files); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+	}
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+
+	// A config file mounted from a ConfigMap of the manifests is not on disk in CI
+	if path != "" {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			if content, ok := files[filepath.Base(path)]; ok {
+				tmp, err := writeTemp(content)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					return 2
+				}
+				defer os.Remove(tmp)
+				path = tmp
+			}
+		}
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Println("Configuration is invalid:")
+		for _, problem := range strings.Split(err.Error(), "\n") {
+			fmt.Printf("  - %s\n", problem)
+		}
+		return 1
+	}
+
+	fmt.Printf("Configuration is valid: %d database(s), %d registry(ies), %d probe(s), %d plugin(s), MinIO %s\n",
+		len(cfg.Databases), len(cfg.Registries), len(cfg.Probes), len(cfg.Plugins), enabled(cfg.MinIO.Endpoint != ""))
+	if cfg.Discovery.Enabled {
+		fmt.Printf("Discovery is enabled, targets discovered from RainbondCluster %s/%s are not included\n",
+			cfg.Discovery.Namespace, cfg.Discovery.Cluster)
+	}
+	if cfg.TargetAPI.Enabled {
+		fmt.Printf("Target API is enabled, runtime targets from ConfigMap %s/%s are not included\n",
+			cfg.TargetAPI.Namespace, cfg.TargetAPI.ConfigMap)
+	}
+	if cfg.HealthChecks.Enabled {
+		fmt.Printf("HealthChecks are enabled, targets of HealthChecks in namespace %s are not included\n",
+			cfg.HealthChecks.Namespace)
+	}
+	return 0
+}
+
+// loadManifest exports the ConfigMap and Secret keys of a manifest as environment variables
+// Keys that are not valid variable names, such as config.yaml, are collected into files
+func loadManifest(path string, files map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer f.Close()
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		var obj manifestObject
+		if err := decoder.Decode(&obj); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to parse manifest %s: %w", path, err)
+		}
+
+		data := make(map[string]string)
+		switch obj.Kind {
+		case "ConfigMap":
+			data = obj.Data
+		case "Secret":
+			for key, value := range obj.Data {
+				decoded, err := base64.StdEncoding.DecodeString(value)
+				if err != nil {
+					return fmt.Errorf("secret %s in %s: key %s is not valid base64: %w", obj.Metadata.Name, path, key, err)
+				}
+				data[key] = string(decoded)
+			}
+			for key, value := range obj.StringData {
+				data[key] = value
+			}
+		default:
+			continue
+		}
+
+		for key, value := range data {
+			if !envName.MatchString(key) {
+				files[key] = value
+				continue
+			}
+			os.Setenv(key, value)
+		}
+	}
+}
+
+// writeTemp writes content to a temporary file and returns its path
+func writeTemp(content string) (string, error) {
+	f, err := os.CreateTemp("", "health-console-config-*.yaml")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary config file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write temporary config file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// enabled describes a boolean setting
+func enabled(on bool) string {
+	if on {
+		return "enabled"
+	}
+	return "disabled"
+}